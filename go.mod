@@ -3,6 +3,7 @@ module github.com/stickermule/rump
 go 1.12
 
 require (
+	github.com/klauspost/compress v1.11.13
 	github.com/mediocregopher/radix/v3 v3.2.3
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 )
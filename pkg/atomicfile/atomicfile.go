@@ -0,0 +1,55 @@
+// Package atomicfile replaces files atomically.
+//
+// Content goes to a temp file next to the target, synced and renamed in
+// place only once complete, so an interrupted write never leaves a
+// partial file, keeping the previous one instead.
+package atomicfile
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write replaces path with what write writes, unless write fails
+// or ctx is done meanwhile.
+func Write(ctx context.Context, path string, write func(w io.Writer) error) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// Remove the temp file unless renamed in place.
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err = write(f); err != nil {
+		return err
+	}
+
+	// Writers may return early once ctx is done, a partial content
+	// must never replace a complete one.
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// Make sure the content is on disk before replacing path.
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	// TempFile is only readable by the owner, unlike os.Create.
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,91 @@
+package atomicfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// previous creates a temp dir holding a file with previous content.
+func previous(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "rump")
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	path := filepath.Join(dir, "dump")
+	if err := ioutil.WriteFile(path, []byte("previous"), 0600); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	return dir, path
+}
+
+// check compares the content of path and makes sure no temp file is left.
+func check(t *testing.T, dir, path, expected string) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil || string(b) != expected {
+		t.Errorf("expected: %q, result: %q, %v", expected, b, err)
+	}
+
+	tmp, _ := filepath.Glob(filepath.Join(dir, ".*.tmp*"))
+	if len(tmp) != 0 {
+		t.Errorf("temp files should be removed, result: %v", tmp)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, path := previous(t)
+	defer os.RemoveAll(dir)
+
+	err := Write(context.Background(), path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "current")
+		return err
+	})
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	check(t, dir, path, "current")
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("expected: 0644, result: %v", fi.Mode().Perm())
+	}
+}
+
+func TestWriteFailed(t *testing.T) {
+	dir, path := previous(t)
+	defer os.RemoveAll(dir)
+
+	err := Write(context.Background(), path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return fmt.Errorf("failed")
+	})
+	if err == nil {
+		t.Error("failed write should fail")
+	}
+
+	check(t, dir, path, "previous")
+}
+
+func TestWriteCanceled(t *testing.T) {
+	dir, path := previous(t)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Writers returning early without an error are still incomplete.
+	err := Write(ctx, path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "partial")
+		return err
+	})
+	if err != context.Canceled {
+		t.Errorf("expected: %v, result: %v", context.Canceled, err)
+	}
+
+	check(t, dir, path, "previous")
+}
@@ -0,0 +1,237 @@
+// Package checkpoint persists the progress of a sync, to resume it.
+//
+// Progress is tracked per stream, the SCAN cursor of a Redis node or the
+// byte offset of a file, and only advances once the writer acknowledged
+// every Payload read before it.
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stickermule/rump/pkg/atomicfile"
+)
+
+// DefaultInterval is the default time between State saves.
+const DefaultInterval = 5 * time.Second
+
+// State is the progress of a sync, saved to a state file as JSON.
+// Source and Target identify the sync, with redacted URIs.
+// Nodes are the SCAN positions of a Redis source, by node address,
+// "" for a single node.
+// Offset is the byte offset of a file source, after the last record written.
+// Written counts the acknowledged Payloads, across resumes.
+// Dropped counts the Payloads dropped by stages, across resumes.
+type State struct {
+	Source  string          `json:"source"`
+	Target  string          `json:"target"`
+	Nodes   map[string]Node `json:"nodes,omitempty"`
+	Offset  int64           `json:"offset,omitempty"`
+	Written int64           `json:"written"`
+	Dropped int64           `json:"dropped,omitempty"`
+	Time    time.Time       `json:"time"`
+}
+
+// Node is the SCAN position of a Redis node.
+// Cursor is the SCAN cursor to continue from, Done once fully scanned.
+type Node struct {
+	Cursor string `json:"cursor"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+// Load reads a state file, or returns an empty State if it doesn't exist.
+func Load(path string) (State, error) {
+	var s State
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
+
+// Save writes a state file, via a synced temp file renamed in place,
+// so a crash never leaves a partial state.
+func Save(path string, s State) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Saved also once interrupted, never canceled.
+	return atomicfile.Write(context.Background(), path, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// Tracker tracks the progress of a sync from Marks, positions of streams
+// registered by readers in order.
+// A nil Tracker tracks nothing, its Marks being nil too.
+type Tracker struct {
+	mu     sync.Mutex
+	state  State
+	queues map[string][]*Mark
+}
+
+// NewTracker creates a Tracker, starting from a State.
+func NewTracker(s State) *Tracker {
+	if s.Nodes == nil {
+		s.Nodes = map[string]Node{}
+	}
+
+	return &Tracker{
+		state:  s,
+		queues: map[string][]*Mark{},
+	}
+}
+
+// Mark registers the next position of a stream.
+// apply updates the State with it, once the Mark and every previous Mark
+// of the stream are done.
+func (t *Tracker) Mark(stream string, apply func(*State)) *Mark {
+	if t == nil {
+		return nil
+	}
+
+	m := &Mark{
+		t:       t,
+		stream:  stream,
+		pending: 1,
+		apply:   apply,
+	}
+
+	t.mu.Lock()
+	t.queues[stream] = append(t.queues[stream], m)
+	t.mu.Unlock()
+
+	return m
+}
+
+// State returns a copy of the current State.
+func (t *Tracker) State() State {
+	if t == nil {
+		return State{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.state
+	s.Nodes = make(map[string]Node, len(t.state.Nodes))
+	for addr, n := range t.state.Nodes {
+		s.Nodes[addr] = n
+	}
+
+	return s
+}
+
+// Run saves the State to path every interval, and once more when ctx is
+// done, e.g. interrupted.
+// To be used in an ErrGroup.
+func (t *Tracker) Run(ctx context.Context, path string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return t.save(path)
+		case <-ticker.C:
+			if err := t.save(path); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// save saves the current State.
+func (t *Tracker) save(path string) error {
+	s := t.State()
+	s.Time = time.Now().UTC()
+
+	return Save(path, s)
+}
+
+// advance applies the done Marks at the head of a stream, in order.
+// t.mu must be held.
+func (t *Tracker) advance(stream string) {
+	q := t.queues[stream]
+	for len(q) > 0 && q[0].pending == 0 {
+		q[0].apply(&t.state)
+		q[0] = nil
+		q = q[1:]
+	}
+	t.queues[stream] = q
+}
+
+// Mark is a stream position, done once the reader released it and
+// every Payload read before it was acknowledged.
+// A nil Mark does nothing.
+type Mark struct {
+	t       *Tracker
+	stream  string
+	pending int
+	apply   func(*State)
+}
+
+// Add holds the Mark until the returned ack is called, once a Payload
+// is written, or drop, once dropped by a stage.
+// Acks count in Written, drops in Dropped. Only the first call counts,
+// either can be called more than once.
+func (m *Mark) Add() (ack, drop func()) {
+	if m == nil {
+		return nil, nil
+	}
+
+	m.t.mu.Lock()
+	m.pending++
+	m.t.mu.Unlock()
+
+	var once sync.Once
+	done := func(count *int64) func() {
+		return func() {
+			once.Do(func() {
+				m.t.mu.Lock()
+				defer m.t.mu.Unlock()
+
+				*count++
+				m.release()
+			})
+		}
+	}
+
+	return done(&m.t.state.Written), done(&m.t.state.Dropped)
+}
+
+// Done releases the reader hold on the Mark, once every Payload
+// read before it was added.
+func (m *Mark) Done() {
+	if m == nil {
+		return
+	}
+
+	m.t.mu.Lock()
+	defer m.t.mu.Unlock()
+
+	m.release()
+}
+
+// release decrements pending, advancing the stream when done.
+// t.mu must be held.
+func (m *Mark) release() {
+	m.pending--
+	if m.pending == 0 {
+		m.t.advance(m.stream)
+	}
+}
@@ -0,0 +1,107 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker(State{Written: 10})
+	offset := func(o int64) func(*State) {
+		return func(s *State) { s.Offset = o }
+	}
+
+	first := tr.Mark("file", offset(1))
+	ack1, _ := first.Add()
+	first.Done()
+
+	second := tr.Mark("file", offset(2))
+	ack2, _ := second.Add()
+	second.Done()
+
+	// Acknowledged out of order, the offset waits for the first Mark.
+	ack2()
+	if s := tr.State(); s.Offset != 0 || s.Written != 11 {
+		t.Errorf("expected offset 0 and 11 written, got %d and %d", s.Offset, s.Written)
+	}
+
+	ack1()
+	ack1()
+	if s := tr.State(); s.Offset != 2 || s.Written != 12 {
+		t.Errorf("expected offset 2 and 12 written, got %d and %d", s.Offset, s.Written)
+	}
+
+	// Dropped Payloads advance the stream, without counting as written.
+	third := tr.Mark("file", offset(3))
+	ack3, drop3 := third.Add()
+	third.Done()
+	drop3()
+	ack3()
+	if s := tr.State(); s.Offset != 3 || s.Written != 12 || s.Dropped != 1 {
+		t.Errorf("expected offset 3, 12 written and 1 dropped, got %d, %d and %d", s.Offset, s.Written, s.Dropped)
+	}
+
+	// Marks are held by the reader until done, even without Payloads.
+	node := tr.Mark("10.0.0.1:6379", func(s *State) {
+		s.Nodes["10.0.0.1:6379"] = Node{Cursor: "0", Done: true}
+	})
+	if _, ok := tr.State().Nodes["10.0.0.1:6379"]; ok {
+		t.Error("mark should not apply before done")
+	}
+	node.Done()
+	if !tr.State().Nodes["10.0.0.1:6379"].Done {
+		t.Error("mark should apply once done")
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tr *Tracker
+
+	m := tr.Mark("file", func(s *State) {})
+	if ack, drop := m.Add(); ack != nil || drop != nil {
+		t.Error("nil tracker should not acknowledge")
+	}
+	m.Done()
+
+	if !reflect.DeepEqual(tr.State(), State{}) {
+		t.Error("nil tracker should have an empty state")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rump.state")
+
+	s, err := Load(path)
+	if err != nil || !reflect.DeepEqual(s, State{}) {
+		t.Error("missing state should load empty")
+	}
+
+	expected := State{
+		Source:  "redis://s",
+		Target:  "redis://t",
+		Nodes:   map[string]Node{"": {Cursor: "42"}},
+		Written: 100,
+		Dropped: 3,
+	}
+	if err := Save(path, expected); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Load(path)
+	if err != nil || !reflect.DeepEqual(expected, s) {
+		t.Errorf("expected %v, got %v %v", expected, s, err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Error("temp state files should be renamed")
+	}
+}
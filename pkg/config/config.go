@@ -4,26 +4,152 @@ package config
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"strings"
+
+	"github.com/stickermule/rump/pkg/file"
+	"github.com/stickermule/rump/pkg/filter"
+	"github.com/stickermule/rump/pkg/limit"
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/rdb"
+	"github.com/stickermule/rump/pkg/redis"
+	"github.com/stickermule/rump/pkg/transform"
+)
+
+// Sync modes.
+// ModeDump copies keys with DUMP/RESTORE.
+// ModeNative writes keys with type-native commands, like SET or HSET.
+const (
+	ModeDump   = "dump"
+	ModeNative = "native"
 )
 
+// protectEnv lists protected host patterns, comma-separated,
+// for every run of a shell or CI job.
+const protectEnv = "RUMP_PROTECT"
+
 // Resource can be either Redis (isRedis) or file.
 // URI is either a Redis URI or a file path.
+// IsCluster marks a Redis Cluster URI (redis+cluster://).
+// IsSentinel marks a Sentinel-managed Redis URI (redis+sentinel://).
+// IsTLS marks an encrypted Redis URI (rediss://, rediss+cluster://, ...).
+// IsRDB marks a Redis RDB file (*.rdb).
+// IsRESP marks a RESP command stream file (*.resp, *.aof).
+// IsJSONL marks a JSON Lines file (*.jsonl).
+// Replica reads from a Sentinel replica instead of the primary.
 type Resource struct {
-	URI     string
-	IsRedis bool
+	URI        string
+	IsRedis    bool
+	IsCluster  bool
+	IsSentinel bool
+	IsTLS      bool
+	IsRDB      bool
+	IsRESP     bool
+	IsJSONL    bool
+	Replica    bool
+}
+
+// TLS holds certificate paths and options for rediss:// connections.
+// CA is a PEM bundle used to verify servers.
+// Cert and Key are an optional client certificate pair.
+// ServerName overrides the name verified against server certificates.
+// Insecure skips server certificate verification.
+type TLS struct {
+	CA         string
+	Cert       string
+	Key        string
+	ServerName string
+	Insecure   bool
 }
 
 // Config represents the current source and target config.
 // Source and target are Resources.
-// Silent disables verbose mode.
+// Silent disables progress output.
 // TTL enables keys TTL sync.
+// TLS applies to every rediss:// Resource.
+// Match optionally limits the sync to keys matching any glob pattern.
+// Type optionally limits the sync to keys of a type.
+// Exclude drops keys matching any glob pattern.
+// Allow and Deny are paths to newline-separated key lists.
+// StripPrefix, Rename rules and AddPrefix rewrite keys, in this order.
+// Map is the path of a key mapping file, overriding other rewrites.
+// Workers is the number of parallel Redis readers and writers.
+// BatchSize and BatchBytes limit the RESTOREs pipelined at once.
+// Compress is the file target codec: none, gzip or zstd.
+// IgnoreIntegrity reads truncated or corrupt file sources with warnings.
+// RDBDB limits an RDB source to a single DB, 0 by default, or rdb.AllDBs,
+// and is the DB keys are loaded into from an RDB target.
+// Mode is either ModeDump or ModeNative.
+// Follow keeps syncing keys changed after the initial copy until interrupted.
+// Mirror deletes target keys missing from the source after the copy,
+// only listing them if MirrorDryRun, and deleting nothing if more
+// than MirrorMax, unless 0.
+// Flush empties the target DB before writing, asynchronously if FlushAsync.
+// Yes confirms Flush without asking.
+// State is the checkpoint file of the sync, continued from if Resume.
+// MaxOps and MaxBytes limit the keys and value bytes read per second from
+// a Redis source, backing off while it exceeds the Throttle thresholds.
+// ScanCount is the SCAN COUNT hint of a Redis source, and of the target
+// scan when mirroring, 0 for the server default.
+// Bus is the capacity of the Buses between stages, or if BusAuto the
+// initial size of the buffer before the writer, growing up to BusMax,
+// and shrinking while the heap exceeds BusMemory bytes.
+// LogLevel and LogFormat configure the logger of every component.
 type Config struct {
-	Source Resource
-	Target Resource
-	Silent bool
-	TTL    bool
+	Source          Resource
+	Target          Resource
+	Silent          bool
+	TTL             bool
+	TLS             TLS
+	Match           []string
+	Type            string
+	Exclude         []string
+	Allow           string
+	Deny            string
+	StripPrefix     string
+	Rename          []string
+	AddPrefix       string
+	Map             string
+	Workers         int
+	BatchSize       int
+	BatchBytes      int
+	Compress        string
+	IgnoreIntegrity bool
+	RDBDB           int
+	Mode            string
+	Follow          bool
+	Mirror          bool
+	MirrorDryRun    bool
+	MirrorMax       int
+	Flush           bool
+	FlushAsync      bool
+	Yes             bool
+	State           string
+	Resume          bool
+	MaxOps          int
+	MaxBytes        int
+	Throttle        limit.Thresholds
+	ScanCount       int
+	Bus             int
+	BusAuto         bool
+	BusMax          int
+	BusMemory       uint64
+	LogLevel        log.Level
+	LogFormat       string
+}
+
+// list is a repeatable string flag.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(s string) error {
+	*l = append(*l, s)
+	return nil
 }
 
 // exit will exit and print the usage.
@@ -34,28 +160,92 @@ func exit(e error) {
 	os.Exit(1)
 }
 
+// resource creates a Resource, detecting the kind of URI from its scheme.
+// Anything without a Redis scheme is a file path.
+func resource(uri string) Resource {
+	r := Resource{
+		URI: uri,
+	}
+
+	i := strings.Index(uri, "://")
+	if i < 0 {
+		r.IsRDB = strings.HasSuffix(uri, ".rdb")
+		r.IsRESP = strings.HasSuffix(uri, ".resp") || strings.HasSuffix(uri, ".aof")
+		r.IsJSONL = strings.HasSuffix(uri, ".jsonl")
+		return r
+	}
+
+	switch uri[:i] {
+	case "redis", "rediss":
+		r.IsRedis = true
+	case "redis+cluster", "rediss+cluster":
+		r.IsRedis = true
+		r.IsCluster = true
+	case "redis+sentinel", "rediss+sentinel":
+		r.IsRedis = true
+		r.IsSentinel = true
+	}
+
+	r.IsTLS = r.IsRedis && strings.HasPrefix(uri, "rediss")
+
+	return r
+}
+
+// Redacted returns the URI with any password masked, safe to log or record.
+func (r Resource) Redacted() string {
+	if !r.IsRedis {
+		return r.URI
+	}
+
+	i := strings.Index(r.URI, "://") + len("://")
+	end := strings.Index(r.URI[i:], "/")
+	if end < 0 {
+		end = len(r.URI) - i
+	}
+
+	at := strings.LastIndex(r.URI[i:i+end], "@")
+	if at < 0 {
+		return r.URI
+	}
+
+	user := r.URI[i : i+at]
+	if c := strings.Index(user, ":"); c >= 0 {
+		user = user[:c+1] + "xxxxx"
+	} else {
+		user = "xxxxx"
+	}
+
+	return r.URI[:i] + user + r.URI[i+at:]
+}
+
+// hosts returns the host:port addresses of a Redis URI,
+// seed nodes for a Cluster and Sentinels for a Sentinel.
+func (r Resource) hosts() []string {
+	if !r.IsRedis {
+		return nil
+	}
+
+	rest := r.URI[strings.Index(r.URI, "://")+len("://"):]
+	if end := strings.Index(rest, "/"); end >= 0 {
+		rest = rest[:end]
+	}
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		rest = rest[at+1:]
+	}
+
+	return strings.Split(rest, ",")
+}
+
 // validate makes sure from and to are Redis URIs or file paths,
 // and generates the final Config.
 func validate(from, to string, silent, ttl bool) (Config, error) {
 	cfg := Config{
-		Source: Resource{
-			URI: from,
-		},
-		Target: Resource{
-			URI: to,
-		},
+		Source: resource(from),
+		Target: resource(to),
 		Silent: silent,
 		TTL:    ttl,
 	}
 
-	if strings.HasPrefix(from, "redis://") {
-		cfg.Source.IsRedis = true
-	}
-
-	if strings.HasPrefix(to, "redis://") {
-		cfg.Target.IsRedis = true
-	}
-
 	// Guard from incorrect usage.
 	switch {
 	case cfg.Source.URI == "":
@@ -69,22 +259,417 @@ func validate(from, to string, silent, ttl bool) (Config, error) {
 	return cfg, nil
 }
 
+// validateReplica makes sure replica reads are only used with a Sentinel source.
+func validateReplica(cfg Config, replica bool) (Config, error) {
+	if replica && !cfg.Source.IsSentinel {
+		return cfg, fmt.Errorf("replica requires a redis+sentinel:// source")
+	}
+	cfg.Source.Replica = replica
+
+	return cfg, nil
+}
+
+// validateTLS makes sure TLS options are complete and actually used.
+func validateTLS(cfg Config, t TLS) (Config, error) {
+	switch {
+	case t == TLS{}:
+	case !cfg.Source.IsTLS && !cfg.Target.IsTLS:
+		return cfg, fmt.Errorf("tls options require a rediss:// URI")
+	case (t.Cert == "") != (t.Key == ""):
+		return cfg, fmt.Errorf("tls-cert and tls-key must be used together")
+	}
+	cfg.TLS = t
+
+	return cfg, nil
+}
+
+// validateFilter makes sure the key type is one SCAN understands.
+func validateFilter(cfg Config, match []string, typ string) (Config, error) {
+	if typ != "" {
+		known := false
+		for _, t := range filter.Types {
+			known = known || t == typ
+		}
+		if !known {
+			return cfg, fmt.Errorf("type must be one of %s", strings.Join(filter.Types, ", "))
+		}
+	}
+	cfg.Match = match
+	cfg.Type = typ
+
+	return cfg, nil
+}
+
+// validateRename makes sure rename rules are valid pattern=replacement pairs.
+func validateRename(cfg Config, rename []string) (Config, error) {
+	for _, r := range rename {
+		if _, err := transform.ParseRule(r); err != nil {
+			return cfg, err
+		}
+	}
+	cfg.Rename = rename
+
+	return cfg, nil
+}
+
+// validateWorkers makes sure there's at least one worker.
+func validateWorkers(cfg Config, workers int) (Config, error) {
+	if workers < 1 {
+		return cfg, fmt.Errorf("workers must be at least 1")
+	}
+	cfg.Workers = workers
+
+	return cfg, nil
+}
+
+// validateBatch makes sure batches can hold at least one key.
+func validateBatch(cfg Config, size, bytes int) (Config, error) {
+	if size < 1 || bytes < 1 {
+		return cfg, fmt.Errorf("batch and batch-bytes must be at least 1")
+	}
+	cfg.BatchSize = size
+	cfg.BatchBytes = bytes
+
+	return cfg, nil
+}
+
+// validateCompress makes sure the codec is known,
+// defaulting to the one implied by the file target extension.
+func validateCompress(cfg Config, compress string) (Config, error) {
+	if compress == "" {
+		compress = file.Compression(cfg.Target.URI)
+	}
+
+	known := false
+	for _, c := range file.Codecs {
+		known = known || c == compress
+	}
+	switch {
+	case !known:
+		return cfg, fmt.Errorf("compress must be one of %s", strings.Join(file.Codecs, ", "))
+	case (cfg.Target.IsRedis || cfg.Target.IsRDB || cfg.Target.IsRESP || cfg.Target.IsJSONL) && compress != file.None:
+		return cfg, fmt.Errorf("compress requires a rump file target")
+	}
+	cfg.Compress = compress
+
+	return cfg, nil
+}
+
+// validateRDB makes sure the DB selection is only used with RDB files.
+func validateRDB(cfg Config, db int) (Config, error) {
+	switch {
+	case db != 0 && !cfg.Source.IsRDB && !cfg.Target.IsRDB:
+		return cfg, fmt.Errorf("rdb-db requires a .rdb source or target")
+	case db < rdb.AllDBs:
+		return cfg, fmt.Errorf("rdb-db must be a DB number")
+	}
+	cfg.RDBDB = db
+
+	return cfg, nil
+}
+
+// validateMode makes sure the mode is known,
+// and native commands are only written to Redis, RESP or JSONL targets.
+func validateMode(cfg Config, mode string) (Config, error) {
+	switch {
+	case mode != ModeDump && mode != ModeNative:
+		return cfg, fmt.Errorf("mode must be one of %s, %s", ModeDump, ModeNative)
+	case mode == ModeNative && !cfg.Target.IsRedis && !cfg.Target.IsRESP && !cfg.Target.IsJSONL:
+		return cfg, fmt.Errorf("native mode requires a redis, .resp, .aof or .jsonl target")
+	case cfg.Source.IsRESP:
+		return cfg, fmt.Errorf("resp files are only supported as target")
+	}
+	cfg.Mode = mode
+
+	return cfg, nil
+}
+
+// validateFollow makes sure changes are only followed between Redis DBs.
+func validateFollow(cfg Config, follow bool) (Config, error) {
+	if follow && (!cfg.Source.IsRedis || !cfg.Target.IsRedis) {
+		return cfg, fmt.Errorf("follow requires a redis source and target")
+	}
+	cfg.Follow = follow
+
+	return cfg, nil
+}
+
+// validateMirror makes sure deletions are only mirrored between Redis DBs,
+// with target keys named as on the source.
+func validateMirror(cfg Config, mirror, dryRun bool, max int, rewrite bool) (Config, error) {
+	switch {
+	case dryRun && !mirror:
+		return cfg, fmt.Errorf("mirror-dry-run requires mirror")
+	case max < 0:
+		return cfg, fmt.Errorf("mirror-max must be at least 0")
+	case !mirror:
+	case !cfg.Source.IsRedis || !cfg.Target.IsRedis:
+		return cfg, fmt.Errorf("mirror requires a redis source and target")
+	case cfg.Follow:
+		return cfg, fmt.Errorf("mirror can't be used with follow")
+	case rewrite:
+		return cfg, fmt.Errorf("mirror can't be used with key rewrites")
+	}
+	cfg.Mirror = mirror
+	cfg.MirrorDryRun = dryRun
+	cfg.MirrorMax = max
+
+	return cfg, nil
+}
+
+// validateFlush makes sure only Redis targets are flushed,
+// never the source or a host matching a protected glob pattern,
+// with or without port.
+func validateFlush(cfg Config, flush, async bool, protect []string) (Config, error) {
+	switch {
+	case async && !flush:
+		return cfg, fmt.Errorf("flush-async requires flush")
+	case !flush:
+	case !cfg.Target.IsRedis:
+		return cfg, fmt.Errorf("flush requires a redis target")
+	case cfg.Target.URI == cfg.Source.URI:
+		return cfg, fmt.Errorf("flush target is the source")
+	}
+
+	for _, host := range cfg.Target.hosts() {
+		name, _, err := net.SplitHostPort(host)
+		if err != nil {
+			name = host
+		}
+		for _, p := range protect {
+			if flush && (filter.Glob(p, host) || filter.Glob(p, name)) {
+				return cfg, fmt.Errorf("flush refused, %s is protected by %s", host, p)
+			}
+		}
+	}
+	cfg.Flush = flush
+	cfg.FlushAsync = async
+
+	return cfg, nil
+}
+
+// validateResume makes sure checkpoints are only used for syncs that can
+// resume, from a Redis or Rump file source to a Redis target, and never
+// flush what was already copied.
+func validateResume(cfg Config, state string, resume bool) (Config, error) {
+	switch {
+	case resume && state == "":
+		return cfg, fmt.Errorf("resume requires state")
+	case state == "":
+	case !cfg.Target.IsRedis:
+		return cfg, fmt.Errorf("state requires a redis target")
+	case cfg.Source.IsRDB || cfg.Source.IsRESP || cfg.Source.IsJSONL:
+		return cfg, fmt.Errorf("state requires a redis or rump file source")
+	case cfg.Follow:
+		return cfg, fmt.Errorf("state can't be used with follow")
+	case resume && cfg.Flush:
+		return cfg, fmt.Errorf("resume can't be used with flush")
+	}
+	cfg.State = state
+	cfg.Resume = resume
+
+	return cfg, nil
+}
+
+// validateLimit makes sure read limits are positive,
+// and only set for Redis sources.
+func validateLimit(cfg Config, maxOps, maxBytes int, throttle limit.Thresholds) (Config, error) {
+	switch {
+	case maxOps < 0 || maxBytes < 0:
+		return cfg, fmt.Errorf("max-ops and max-bytes-per-sec can't be negative")
+	case throttle.Latency < 0 || throttle.CPU < 0 || throttle.Ops < 0:
+		return cfg, fmt.Errorf("throttle thresholds can't be negative")
+	case !cfg.Source.IsRedis && (maxOps > 0 || maxBytes > 0 || throttle.Enabled()):
+		return cfg, fmt.Errorf("read limits require a redis source")
+	}
+	cfg.MaxOps = maxOps
+	cfg.MaxBytes = maxBytes
+	cfg.Throttle = throttle
+
+	return cfg, nil
+}
+
+// validateScanCount makes sure the SCAN COUNT hint is positive,
+// and only set for Redis sources.
+func validateScanCount(cfg Config, count int) (Config, error) {
+	switch {
+	case count < 0:
+		return cfg, fmt.Errorf("scan-count can't be negative")
+	case count > 0 && !cfg.Source.IsRedis:
+		return cfg, fmt.Errorf("scan-count requires a redis source")
+	}
+	cfg.ScanCount = count
+
+	return cfg, nil
+}
+
+// validateBus makes sure the Bus holds at least a Payload,
+// and can only grow when auto-tuned.
+func validateBus(cfg Config, size int, auto bool, max, memory int) (Config, error) {
+	switch {
+	case size < 1:
+		return cfg, fmt.Errorf("bus must be at least 1")
+	case auto && max < size:
+		return cfg, fmt.Errorf("bus-max must be at least bus")
+	case memory < 0:
+		return cfg, fmt.Errorf("bus-memory can't be negative")
+	}
+	cfg.Bus = size
+	cfg.BusAuto = auto
+	cfg.BusMax = max
+	cfg.BusMemory = uint64(memory)
+
+	return cfg, nil
+}
+
+// validateLog makes sure the log level and format are known.
+func validateLog(cfg Config, level, format string) (Config, error) {
+	l, err := log.ParseLevel(level)
+	if err != nil {
+		return cfg, err
+	}
+
+	switch format {
+	case log.Text, log.JSON:
+	default:
+		return cfg, fmt.Errorf("unknown log format %s", format)
+	}
+	cfg.LogLevel = l
+	cfg.LogFormat = format
+
+	return cfg, nil
+}
+
 // Parse parses the command line flags and returns a Config.
 func Parse() Config {
-	example := "example: redis://127.0.0.1:6379/0 or /tmp/dump.rump"
+	example := "example: redis://127.0.0.1:6379/0, rediss://127.0.0.1:6380/0, redis+cluster://10.0.0.1:6379,10.0.0.2:6379, redis+sentinel://10.0.0.1:26379/mymaster/0, /tmp/dump.rump, /tmp/dump.rdb, /tmp/dump.resp or /tmp/dump.jsonl"
 	from := flag.String("from", "", example)
 	to := flag.String("to", "", example)
-	silent := flag.Bool("silent", false, "optional, no verbose output")
+	silent := flag.Bool("silent", false, "optional, no progress output")
 	ttl := flag.Bool("ttl", false, "optional, enable ttl sync")
+	replica := flag.Bool("replica", false, "optional, read from a sentinel replica")
+	var t TLS
+	flag.StringVar(&t.CA, "tls-ca", "", "optional, CA bundle to verify rediss:// servers")
+	flag.StringVar(&t.Cert, "tls-cert", "", "optional, client certificate for rediss://")
+	flag.StringVar(&t.Key, "tls-key", "", "optional, client key for rediss://")
+	flag.StringVar(&t.ServerName, "tls-server-name", "", "optional, override the rediss:// server name")
+	flag.BoolVar(&t.Insecure, "tls-insecure", false, "optional, skip rediss:// server verification")
+	var match list
+	flag.Var(&match, "match", "optional, repeatable, only sync keys matching a glob pattern, e.g. session:*")
+	typ := flag.String("type", "", "optional, only sync keys of a type: "+strings.Join(filter.Types, ", "))
+	var exclude list
+	flag.Var(&exclude, "exclude", "optional, repeatable, skip keys matching a glob pattern, e.g. pii:*")
+	allow := flag.String("allow", "", "optional, only sync keys listed in a newline-separated file")
+	deny := flag.String("deny", "", "optional, skip keys listed in a newline-separated file")
+	stripPrefix := flag.String("strip-prefix", "", "optional, remove a prefix from keys, e.g. prod:")
+	var rename list
+	flag.Var(&rename, "rename", "optional, repeatable, rewrite keys with a regexp=replacement rule, e.g. ^prod:(.*)=stg:$1")
+	addPrefix := flag.String("add-prefix", "", "optional, add a prefix to keys, e.g. stg:")
+	mapping := flag.String("map", "", "optional, rename keys listed in a file of old<TAB>new lines")
+	workers := flag.Int("workers", 1, "optional, number of parallel redis readers and writers")
+	batch := flag.Int("batch", redis.DefaultBatchSize, "optional, max keys restored per pipeline")
+	batchBytes := flag.Int("batch-bytes", redis.DefaultBatchBytes, "optional, max value bytes restored per pipeline")
+	ignoreIntegrity := flag.Bool("ignore-integrity", false, "optional, read truncated or corrupt file sources with warnings")
+	rdbDB := flag.Int("rdb-db", 0, "optional, only read keys of a DB from a .rdb source, -1 for all DBs, or DB to load keys into from a .rdb target, default 0")
+	mode := flag.String("mode", ModeDump, "optional, "+ModeDump+" or "+ModeNative+", reading and writing keys with type-native commands like HSCAN or HSET, across Redis versions")
+	follow := flag.Bool("follow", false, "optional, keep syncing changes from keyspace notifications after the initial copy, until interrupted; enables notify-keyspace-events KA on the source if needed, restored on exit")
+	mirror := flag.Bool("mirror", false, "optional, after the copy, delete target keys missing from the source, respecting key filters")
+	mirrorDryRun := flag.Bool("mirror-dry-run", false, "optional, list the keys mirror would delete, without deleting them")
+	mirrorMax := flag.Int("mirror-max", redis.DefaultMirrorMax, "optional, delete nothing if mirror would delete more keys, 0 for no limit")
+	flush := flag.Bool("flush", false, "optional, flush the target DB before sync, asking for confirmation")
+	flushAsync := flag.Bool("flush-async", false, "optional, flush the target DB with FLUSHDB ASYNC")
+	yes := flag.Bool("yes", false, "optional, flush without asking for confirmation")
+	var protect list
+	flag.Var(&protect, "protect", "optional, repeatable, never flush hosts matching a glob pattern, e.g. *.prod.example.com, also read comma-separated from "+protectEnv)
+	state := flag.String("state", "", "optional, checkpoint file saved during a sync to a redis target, e.g. /tmp/rump.state, removed once done")
+	resume := flag.Bool("resume", false, "optional, continue an interrupted sync from its state checkpoint")
+	maxOps := flag.Int("max-ops", 0, "optional, max keys read from a redis source per second, default no limit")
+	maxBytes := flag.Int("max-bytes-per-sec", 0, "optional, max value bytes read from a redis source per second, default no limit")
+	var throttle limit.Thresholds
+	flag.DurationVar(&throttle.Latency, "throttle-latency", 0, "optional, back reads off while the redis source answers INFO slower, e.g. 50ms")
+	flag.Float64Var(&throttle.CPU, "throttle-cpu", 0, "optional, back reads off while the redis source uses more CPU, in percent of a core, e.g. 80")
+	flag.IntVar(&throttle.Ops, "throttle-ops", 0, "optional, back reads off while the redis source serves more ops per second, e.g. 50000")
+	scanCount := flag.Int("scan-count", 0, "optional, SCAN COUNT hint of a redis source, and of the target with -mirror, keys scanned per batch, default the server's (10)")
+	bus := flag.Int("bus", message.DefaultBusSize, "optional, keys buffered between reader and writer")
+	busAuto := flag.Bool("bus-auto", false, "optional, grow the bus while the writer is the bottleneck, shrinking it under memory pressure")
+	busMax := flag.Int("bus-max", 100*message.DefaultBusSize, "optional, max keys buffered with bus-auto")
+	busMemory := flag.Int("bus-memory", 1<<30, "optional, heap bytes above which bus-auto shrinks the bus, 0 for no limit")
+	logLevel := flag.String("log-level", log.InfoLevel.String(), "optional, minimum level logged: "+strings.Join(log.Levels, ", "))
+	logFormat := flag.String("log-format", log.Text, "optional, log format: "+strings.Join(log.Formats, ", "))
+	compress := flag.String("compress", "", "optional, file target compression: "+strings.Join(file.Codecs, ", ")+", default from extension (.gz, .zst)")
 
 	flag.Parse()
 
 	cfg, err := validate(*from, *to, *silent, *ttl)
+	if err == nil {
+		cfg, err = validateReplica(cfg, *replica)
+	}
+	if err == nil {
+		cfg, err = validateTLS(cfg, t)
+	}
+	if err == nil {
+		cfg, err = validateFilter(cfg, match, *typ)
+	}
+	if err == nil {
+		cfg, err = validateRename(cfg, rename)
+	}
+	if err == nil {
+		cfg, err = validateWorkers(cfg, *workers)
+	}
+	if err == nil {
+		cfg, err = validateBatch(cfg, *batch, *batchBytes)
+	}
+	if err == nil {
+		cfg, err = validateCompress(cfg, *compress)
+	}
+	if err == nil {
+		cfg, err = validateRDB(cfg, *rdbDB)
+	}
+	if err == nil {
+		cfg, err = validateMode(cfg, *mode)
+	}
+	if err == nil {
+		cfg, err = validateFollow(cfg, *follow)
+	}
+	if err == nil {
+		rewrite := *stripPrefix != "" || len(rename) > 0 || *addPrefix != "" || *mapping != ""
+		cfg, err = validateMirror(cfg, *mirror, *mirrorDryRun, *mirrorMax, rewrite)
+	}
+	if err == nil {
+		if env := os.Getenv(protectEnv); env != "" {
+			protect = append(protect, strings.Split(env, ",")...)
+		}
+		cfg, err = validateFlush(cfg, *flush, *flushAsync, protect)
+	}
+	if err == nil {
+		cfg, err = validateResume(cfg, *state, *resume)
+	}
+	if err == nil {
+		cfg, err = validateLimit(cfg, *maxOps, *maxBytes, throttle)
+	}
+	if err == nil {
+		cfg, err = validateScanCount(cfg, *scanCount)
+	}
+	if err == nil {
+		cfg, err = validateBus(cfg, *bus, *busAuto, *busMax, *busMemory)
+	}
+	if err == nil {
+		cfg, err = validateLog(cfg, *logLevel, *logFormat)
+	}
 	if err != nil {
 		// we exit here instead of returning so that we can show
 		// the usage examples in case of an error.
 		exit(err)
 	}
 
+	cfg.Exclude = exclude
+	cfg.Allow = *allow
+	cfg.Deny = *deny
+	cfg.StripPrefix = *stripPrefix
+	cfg.AddPrefix = *addPrefix
+	cfg.Map = *mapping
+	cfg.IgnoreIntegrity = *ignoreIntegrity
+	cfg.Yes = *yes
+
 	return cfg
 }
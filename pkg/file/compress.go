@@ -0,0 +1,89 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported compression codecs.
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Codecs are the supported compression codecs.
+var Codecs = []string{None, Gzip, Zstd}
+
+// Magic bytes identifying compressed files.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Compression returns the codec implied by a file extension,
+// e.g. .rump.gz or .rump.zst.
+func Compression(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return Gzip
+	case strings.HasSuffix(path, ".zst"):
+		return Zstd
+	}
+
+	return None
+}
+
+// nopCloser adds a noop Close to an uncompressed Writer.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// compressor wraps w with a codec.
+// Close must be called to flush the compressed stream.
+func compressor(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case "", None:
+		return nopCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	}
+
+	return nil, fmt.Errorf("file: unknown compression %q", codec)
+}
+
+// decompressor detects the codec from the magic bytes of r,
+// and wraps it in a decompressing reader.
+// The returned func releases the decompressor resources.
+func decompressor(r *bufio.Reader) (*bufio.Reader, func(), error) {
+	magic, _ := r.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(gz), func() { gz.Close() }, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zs, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(zs), zs.Close, nil
+	}
+
+	return r, func() {}, nil
+}
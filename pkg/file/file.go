@@ -1,25 +1,70 @@
 // Package file allows reading/writing from/to a Rump file.
-// Rump file protocol is key✝✝value✝✝ttl✝✝key✝✝value✝✝ttl✝✝...
+//
+// Rump files v3 start with a magic header and version,
+// followed by length-prefixed binary records:
+// 0x01 len(key) key len(value) value len(ttl) ttl crc32 ...
+// where lengths are uvarints and crc32 covers the record.
+// A footer records count, bytes, source, creation time and SHA-256
+// digest of the preceding content:
+// 0xff len(count) count len(bytes) bytes ... crc32
+//
+// v2 files, still readable, have the same records without CRC nor footer.
+//
+// Legacy v1 files, still readable, are key✝✝value✝✝ttl✝✝key✝✝value✝✝ttl✝✝...
+//
+// Files can be gzip or zstd compressed, detected on read from magic bytes.
 package file
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"strings"
+	"time"
 
+	"github.com/stickermule/rump/pkg/atomicfile"
+	"github.com/stickermule/rump/pkg/checkpoint"
+	"github.com/stickermule/rump/pkg/filter"
+	"github.com/stickermule/rump/pkg/log"
 	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/progress"
 )
 
 // File can read and write, to a file Path, using the message Bus.
+// Match and Type optionally filter keys on read.
+// Compress is the codec used on write: none, gzip or zstd.
+// Source is the URI recorded in the footer on write.
+// IgnoreIntegrity reads truncated or corrupt files with warnings.
+// Tracker, if set, checkpoints the byte offset of records once written,
+// and resumes reading after the offset of its State.
+// Log logs exits and warnings, nil to discard them.
+// Progress counts keys read and written, with the records of a verified
+// manifest as the total on read.
 type File struct {
-	Path   string
-	Bus    message.Bus
-	Silent bool
-	TTL    bool
+	Path            string
+	Bus             message.Bus
+	Log             *log.Logger
+	TTL             bool
+	Match           []string
+	Type            string
+	Compress        string
+	Source          string
+	IgnoreIntegrity bool
+	Tracker         *checkpoint.Tracker
+	Progress        *progress.Progress
+
+	manifest Manifest
+	warned   bool
 }
 
+// cross is the v1 field separator.
+var cross = []byte("✝✝")
+
+// maxToken is the largest v1 field, same as the Redis max value size.
+const maxToken = 512 << 20
+
 // splitCross is a double-cross (✝✝) custom Scanner Split.
 func splitCross(data []byte, atEOF bool) (advance int, token []byte, err error) {
 
@@ -29,44 +74,269 @@ func splitCross(data []byte, atEOF bool) (advance int, token []byte, err error)
 	}
 
 	// Split at separator
-	if i := strings.Index(string(data), "✝✝"); i >= 0 {
+	if i := bytes.Index(data, cross); i >= 0 {
 		// Separator is 6 bytes long
-		return i + 6, data[0:i], nil
+		return i + len(cross), data[0:i], nil
 	}
 
 	return 0, nil, nil
 }
 
 // New creates the File struct, to be used for reading/writing.
-func New(path string, bus message.Bus, silent, ttl bool) *File {
+func New(path string, bus message.Bus, logger *log.Logger, ttl bool) *File {
 	return &File{
-		Path:   path,
-		Bus:    bus,
-		Silent: silent,
-		TTL:    ttl,
+		Path: path,
+		Bus:  bus,
+		Log:  logger,
+		TTL:  ttl,
 	}
 }
 
-// Log read/write operations unless silent mode enabled
-func (f *File) maybeLog(s string) {
-	if f.Silent {
-		return
+// send pushes a Payload on the Bus, unless filtered out,
+// holding the Mark until acknowledged.
+func (f *File) send(ctx context.Context, p message.Payload, mark *checkpoint.Mark) error {
+	if !filter.MatchAny(f.Match, p.Key) {
+		return nil
+	}
+	if f.Type != "" && filter.DumpType(p.Value) != f.Type {
+		return nil
+	}
+
+	p.Ack, p.Drop = mark.Add()
+
+	select {
+	case <-ctx.Done():
+		f.Log.Debug("exit", log.Fields{log.Phase: "read"})
+		return ctx.Err()
+	case f.Bus <- p:
+		f.Progress.Read()
 	}
-	fmt.Print(s)
+
+	return nil
 }
 
 // Read scans a Rump file and sends Payloads to the message bus.
-func (f *File) Read(ctx context.Context) error {
-	defer close(f.Bus)
+// The compression and file version are detected from its header.
+// Unless IgnoreIntegrity, v3 files are verified before sending any Payload.
+// Records ending before the Tracker offset are read, but not sent.
+func (f *File) Read(ctx context.Context) (err error) {
+	defer f.Bus.CloseOnSuccess(&err)
+
+	if !f.IgnoreIntegrity {
+		m, err := f.Verify()
+		if err != nil {
+			return err
+		}
+		f.Progress.AddTotal(m.Records)
+	}
+
+	start := f.Tracker.State().Offset
+	return f.scan(func(p message.Payload, end int64) error {
+		if end <= start {
+			return nil
+		}
+
+		mark := f.Tracker.Mark("file", func(s *checkpoint.State) {
+			s.Offset = end
+		})
+		defer mark.Done()
+
+		return f.send(ctx, p, mark)
+	})
+}
+
+// Verify reads a whole Rump file, checking records and footer,
+// and returns its Manifest.
+// Legacy v1 and v2 files can't be verified, and have an empty Manifest.
+func (f *File) Verify() (Manifest, error) {
+	var m Manifest
+
+	err := f.scan(func(p message.Payload, end int64) error {
+		return nil
+	})
+	if err == nil {
+		m = f.manifest
+	}
 
+	return m, err
+}
+
+// counter counts the bytes read from r.
+type counter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *counter) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// scan reads a Rump file, calling fn for each Payload
+// with the offset where its record ends, in the decompressed file.
+func (f *File) scan(fn func(p message.Payload, end int64) error) error {
 	d, err := os.Open(f.Path)
 	if err != nil {
 		return err
 	}
 	defer d.Close()
 
+	dr, release, err := decompressor(bufio.NewReader(d))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	c := &counter{r: dr}
+	r := bufio.NewReader(c)
+
+	// Records are read right before fn is called,
+	// the offset is what was consumed from the buffer.
+	at := func(p message.Payload) error {
+		return fn(p, c.n-int64(r.Buffered()))
+	}
+
+	switch v := version(r); v {
+	case version1:
+		f.warnLegacy(v)
+		return f.readV1(r, fn)
+	case version2:
+		f.warnLegacy(v)
+		return f.readV2(r, at)
+	case version3:
+		return f.readV3(r, at)
+	default:
+		return fmt.Errorf("file: unsupported version %d", v)
+	}
+}
+
+// warnLegacy warns, once, that a legacy file has no integrity checks.
+func (f *File) warnLegacy(v int) {
+	if f.warned {
+		return
+	}
+	f.warned = true
+	f.Log.Warn("legacy file, integrity can't be verified", log.Fields{log.Phase: "read", "version": v})
+}
+
+// corrupt fails on integrity errors, or warns if IgnoreIntegrity.
+func (f *File) corrupt(err error) error {
+	if !f.IgnoreIntegrity {
+		return fmt.Errorf("file: %v", err)
+	}
+
+	f.Log.Warn("corrupt file, ignoring integrity", log.Fields{log.Phase: "read", log.Error: err})
+	f.Progress.Error(1)
+	return nil
+}
+
+// readV3 reads checksummed records, then verifies the footer Manifest.
+// Corrupt records are skipped, and truncated files read up to the
+// truncation, only if IgnoreIntegrity.
+func (f *File) readV3(r *bufio.Reader, fn func(message.Payload) error) error {
+	t := newTally()
+	if _, err := r.Discard(len(header)); err != nil {
+		return err
+	}
+	t.Write([]byte(header))
+
+	var records int64
+
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			return f.corrupt(errNoFooter)
+		}
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opRecord:
+			p, err := readRecord(r, true)
+			if err == errCRC {
+				if err := f.corrupt(fmt.Errorf("%v, key %q", err, p.Key)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err == io.ErrUnexpectedEOF {
+				return f.corrupt(errNoFooter)
+			}
+			if err != nil {
+				return err
+			}
+
+			writeRecord(t, p)
+			records++
+
+			if err := fn(p); err != nil {
+				return err
+			}
+		case opFooter:
+			m, err := readManifest(r)
+			if err != nil {
+				return f.corrupt(err)
+			}
+			if _, err := r.ReadByte(); err != io.EOF {
+				return f.corrupt(fmt.Errorf("unexpected data after footer"))
+			}
+
+			switch {
+			case m.Records != records:
+				return f.corrupt(fmt.Errorf("footer has %d records, read %d", m.Records, records))
+			case m.Bytes != t.n:
+				return f.corrupt(fmt.Errorf("footer has %d bytes, read %d", m.Bytes, t.n))
+			case m.Digest != t.digest():
+				return f.corrupt(fmt.Errorf("footer digest mismatch"))
+			}
+
+			f.manifest = m
+			return nil
+		default:
+			return f.corrupt(fmt.Errorf("unknown record type %#x", op))
+		}
+	}
+}
+
+// readV2 reads length-prefixed records, after the header.
+func (f *File) readV2(r *bufio.Reader, fn func(message.Payload) error) error {
+	if _, err := r.Discard(len(header)); err != nil {
+		return err
+	}
+
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if op != opRecord {
+			return fmt.Errorf("file: unknown record type %#x", op)
+		}
+
+		p, err := readRecord(r, false)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+}
+
+// readV1 reads a legacy double-cross separated file.
+// The Scanner reads ahead, offsets are summed from the fields read.
+func (f *File) readV1(r *bufio.Reader, fn func(message.Payload, int64) error) error {
+	var end int64
+
 	// Scan file, split by double-cross separator
-	scanner := bufio.NewScanner(d)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxToken)
 	scanner.Split(splitCross)
 
 	// Scan line by line
@@ -80,13 +350,11 @@ func (f *File) Read(ctx context.Context) error {
 		// trigger next scan to get ttl
 		scanner.Scan()
 		ttl := scanner.Text()
-		select {
-		case <-ctx.Done():
-			fmt.Println("")
-			fmt.Println("file read: exit")
-			return ctx.Err()
-		case f.Bus <- message.Payload{Key: key, Value: value, TTL: ttl}:
-			f.maybeLog("r")
+		end += int64(len(key) + len(value) + len(ttl) + 3*len(cross))
+
+		err := fn(message.Payload{Key: key, Value: value, TTL: ttl}, end)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -98,25 +366,47 @@ func (f *File) Read(ctx context.Context) error {
 }
 
 // Write writes to a Rump file Payloads from the message bus.
+// Files are always written in the v3 format, compressed with Compress.
+// Path is only replaced, atomically, once the Bus is drained,
+// so an interrupted Write never clobbers a previous file.
 func (f *File) Write(ctx context.Context) error {
-	d, err := os.Create(f.Path)
+	return atomicfile.Write(ctx, f.Path, func(w io.Writer) error {
+		return f.write(ctx, w)
+	})
+}
+
+// write writes the Payloads from the message bus, and the footer Manifest
+// once the Bus is drained, unless ctx is done.
+func (f *File) write(ctx context.Context, d io.Writer) error {
+	c, err := compressor(d, f.Compress)
 	if err != nil {
 		return err
 	}
-	defer d.Close()
+
+	// Release the compressor also on errors, closing twice is harmless.
+	defer c.Close()
 
 	// Buffered write to limit system IO calls
-	w := bufio.NewWriter(d)
+	w := bufio.NewWriter(c)
+
+	// Count and hash everything before the footer.
+	t := newTally()
+	out := io.MultiWriter(w, t)
+
+	if _, err := io.WriteString(out, header); err != nil {
+		return err
+	}
 
-	// Flush last open buffers
-	defer w.Flush()
+	m := Manifest{
+		Source:  f.Source,
+		Created: time.Now().UTC(),
+	}
 
 	for f.Bus != nil {
 		select {
 		// Exit early if context done.
 		case <-ctx.Done():
-			fmt.Println("")
-			fmt.Println("file write: exit")
+			f.Log.Debug("exit", log.Fields{log.Phase: "write"})
 			return ctx.Err()
 		// Get Messages from Bus
 		case p, ok := <-f.Bus:
@@ -125,13 +415,32 @@ func (f *File) Write(ctx context.Context) error {
 				f.Bus = nil
 				continue
 			}
-			_, err := w.WriteString(p.Key + "✝✝" + p.Value + "✝✝" + p.TTL + "✝✝")
-			if err != nil {
+			if err := writeRecord(out, p); err != nil {
 				return err
 			}
-			f.maybeLog("w")
+			m.Records++
+			f.Progress.Written(p.Size())
 		}
 	}
 
-	return nil
+	// Readers only close the Bus once everything was read, but the sync
+	// may have been interrupted meanwhile. The footer must never vouch
+	// for a truncated dump.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.Bytes = t.n
+	m.Digest = t.digest()
+
+	if err := writeManifest(w, m); err != nil {
+		return err
+	}
+
+	// Flush last open buffers, then the compressed stream.
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return c.Close()
 }
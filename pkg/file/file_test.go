@@ -7,13 +7,19 @@ package file_test
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/mediocregopher/radix/v3"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/stickermule/rump/pkg/checkpoint"
 	"github.com/stickermule/rump/pkg/file"
+	"github.com/stickermule/rump/pkg/jsonl"
 	"github.com/stickermule/rump/pkg/message"
 	"github.com/stickermule/rump/pkg/redis"
 )
@@ -59,13 +65,13 @@ func TestMain(m *testing.M) {
 
 func TestWriteRead(t *testing.T) {
 	// Read all keys from db1, push to shared message bus
-	source := redis.New(db1, ch, false, false)
+	source := redis.New(db1, ch, nil, false)
 	if err := source.Read(ctx); err != nil {
 		t.Error("error: ", err)
 	}
 
 	// Write rump dump from shared message bus
-	target := file.New(path, ch, false, false)
+	target := file.New(path, ch, nil, false)
 	if err := target.Write(ctx); err != nil {
 		t.Error("error: ", err)
 	}
@@ -74,13 +80,13 @@ func TestWriteRead(t *testing.T) {
 	ch2 := make(message.Bus, 100)
 
 	// Read rump dump file
-	source2 := file.New(path, ch2, false, false)
+	source2 := file.New(path, ch2, nil, false)
 	if err := source2.Read(ctx); err != nil {
 		t.Error("error: ", err)
 	}
 
 	// Write from shared message bus to db2
-	target2 := redis.New(db2, ch2, false, false)
+	target2 := redis.New(db2, ch2, nil, false)
 	if err := target2.Write(ctx); err != nil {
 		t.Error("error: ", err)
 	}
@@ -98,3 +104,300 @@ func TestWriteRead(t *testing.T) {
 		t.Errorf("expected: %v, result: %v", expected, result)
 	}
 }
+
+// Test binary-unsafe and large values round trip through a v2 file
+func TestWriteReadBinary(t *testing.T) {
+	large := strings.Repeat("x", 100000)
+	payloads := []message.Payload{
+		{Key: "cross✝✝key", Value: "value✝✝with✝✝crosses", TTL: "0"},
+		{Key: "large", Value: large, TTL: "1000"},
+		{Key: "empty", Value: "", TTL: "0"},
+	}
+
+	ch := make(message.Bus, 100)
+	for _, p := range payloads {
+		ch <- p
+	}
+	close(ch)
+
+	target := file.New(path, ch, nil, false)
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	ch2 := make(message.Bus, 100)
+	source := file.New(path, ch2, nil, false)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var result []message.Payload
+	for p := range ch2 {
+		result = append(result, p)
+	}
+
+	if !reflect.DeepEqual(payloads, result) {
+		t.Errorf("expected: %v, result: %v", payloads, result)
+	}
+}
+
+// Test legacy v1 files are still readable
+func TestReadV1(t *testing.T) {
+	large := strings.Repeat("x", 100000)
+	err := ioutil.WriteFile(path, []byte("key1✝✝value1✝✝0✝✝key2✝✝"+large+"✝✝1000✝✝"), 0644)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	ch := make(message.Bus, 100)
+	source := file.New(path, ch, nil, false)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var result []message.Payload
+	for p := range ch {
+		result = append(result, p)
+	}
+
+	expected := []message.Payload{
+		{Key: "key1", Value: "value1", TTL: "0"},
+		{Key: "key2", Value: large, TTL: "1000"},
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+// Test compressed files round trip, detecting the codec on read
+func TestWriteReadCompressed(t *testing.T) {
+	for _, codec := range []string{file.Gzip, file.Zstd} {
+		payloads := []message.Payload{
+			{Key: "key1", Value: strings.Repeat("value1", 1000), TTL: "0"},
+			{Key: "key2", Value: "value2", TTL: "1000"},
+		}
+
+		ch := make(message.Bus, 100)
+		for _, p := range payloads {
+			ch <- p
+		}
+		close(ch)
+
+		target := file.New(path, ch, nil, false)
+		target.Compress = codec
+		if err := target.Write(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+
+		ch2 := make(message.Bus, 100)
+		source := file.New(path, ch2, nil, false)
+		if err := source.Read(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+
+		var result []message.Payload
+		for p := range ch2 {
+			result = append(result, p)
+		}
+
+		if !reflect.DeepEqual(payloads, result) {
+			t.Errorf("%s expected: %v, result: %v", codec, payloads, result)
+		}
+	}
+}
+
+// Test reads resume after the last acknowledged record
+func TestReadResume(t *testing.T) {
+	for _, codec := range []string{file.None, file.Gzip} {
+		payloads := []message.Payload{
+			{Key: "key1", Value: "value1", TTL: "0"},
+			{Key: "key2", Value: "value2", TTL: "0"},
+			{Key: "key3", Value: "value3", TTL: "0"},
+		}
+
+		ch := make(message.Bus, 100)
+		for _, p := range payloads {
+			ch <- p
+		}
+		close(ch)
+
+		target := file.New(path, ch, nil, false)
+		target.Compress = codec
+		if err := target.Write(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+
+		// Interrupted after writing the first two keys.
+		tracker := checkpoint.NewTracker(checkpoint.State{})
+		ch2 := make(message.Bus, 100)
+		source := file.New(path, ch2, nil, false)
+		source.Tracker = tracker
+		if err := source.Read(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+		for p := range ch2 {
+			if p.Key != "key3" {
+				p.Ack()
+			}
+		}
+
+		ch3 := make(message.Bus, 100)
+		source = file.New(path, ch3, nil, false)
+		source.Tracker = checkpoint.NewTracker(tracker.State())
+		if err := source.Read(ctx); err != nil {
+			t.Error("error: ", err)
+		}
+
+		var keys []string
+		for p := range ch3 {
+			keys = append(keys, p.Key)
+		}
+
+		if !reflect.DeepEqual([]string{"key3"}, keys) || tracker.State().Written != 2 {
+			t.Errorf("%s expected: [key3], result: %v", codec, keys)
+		}
+	}
+}
+
+// Test truncated and corrupt files are refused, or read with IgnoreIntegrity
+func TestIntegrity(t *testing.T) {
+	payloads := []message.Payload{
+		{Key: "key1", Value: "value1", TTL: "0"},
+		{Key: "key2", Value: "value2", TTL: "1000"},
+	}
+
+	ch := make(message.Bus, 100)
+	for _, p := range payloads {
+		ch <- p
+	}
+	close(ch)
+
+	target := file.New(path, ch, nil, false)
+	target.Source = "redis://redis:6379/5"
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	m, err := file.New(path, nil, nil, false).Verify()
+	if err != nil || m.Records != 2 || m.Source != target.Source {
+		t.Errorf("verify: %v, manifest: %+v", err, m)
+	}
+
+	dump, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	// Cut the footer, then corrupt the first value.
+	truncated := dump[:len(dump)-20]
+	corrupt := []byte(strings.Replace(string(dump), "value1", "valueX", 1))
+
+	for name, b := range map[string][]byte{"truncated": truncated, "corrupt": corrupt} {
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal("error: ", err)
+		}
+
+		ch := make(message.Bus, 100)
+		if err := file.New(path, ch, nil, false).Read(ctx); err == nil {
+			t.Errorf("%s file should not be read", name)
+		}
+		if len(ch) != 0 {
+			t.Errorf("%s file should not send payloads", name)
+		}
+
+		ch = make(message.Bus, 100)
+		source := file.New(path, ch, nil, false)
+		source.IgnoreIntegrity = true
+		if err := source.Read(ctx); err != nil {
+			t.Errorf("%s file should be read with IgnoreIntegrity: %v", name, err)
+		}
+		if len(ch) == 0 {
+			t.Errorf("%s file should send valid payloads", name)
+		}
+	}
+}
+
+// Test a Write interrupted after the Bus is closed writes no footer,
+// so a truncated dump never verifies
+func TestWriteInterrupted(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		os.Remove(path)
+
+		ch := make(message.Bus, 100)
+		ch <- message.Payload{Key: "key1", Value: "value1", TTL: "0"}
+		close(ch)
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		if err := file.New(path, ch, nil, false).Write(cctx); err == nil {
+			t.Fatal("interrupted write should fail")
+		}
+		if _, err := file.New(path, nil, nil, false).Verify(); err == nil {
+			t.Fatal("interrupted write should not verify")
+		}
+	}
+}
+
+// Test an interrupted Write keeps the previous file, removing its temp file
+func TestWriteAtomic(t *testing.T) {
+	if err := ioutil.WriteFile(path, []byte("previous"), 0644); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	ch := make(message.Bus, 100)
+	ch <- message.Payload{Key: "key1", Value: "value1", TTL: "0"}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	target := file.New(path, ch, nil, false)
+	if err := target.Write(cctx); err == nil {
+		t.Error("cancelled write should fail")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil || string(b) != "previous" {
+		t.Errorf("previous file should be kept, result: %q", b)
+	}
+
+	tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*.tmp*"))
+	if len(tmp) != 0 {
+		t.Errorf("temp files should be removed, result: %v", tmp)
+	}
+}
+
+// Test a Write fed by a read failing mid-stream keeps the previous file
+func TestWriteFailedRead(t *testing.T) {
+	if err := ioutil.WriteFile(path, []byte("previous"), 0644); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	// A JSON Lines source with a broken line after its first keys.
+	lines := `{"key":"key1","type":"string","value":"value1"}
+{"key":"key2","type":"string","value":"value2"}
+{"key":"key3","type":
+`
+	src := filepath.Join(filepath.Dir(path), "failed.jsonl")
+	if err := ioutil.WriteFile(src, []byte(lines), 0644); err != nil {
+		t.Fatal("error: ", err)
+	}
+	defer os.Remove(src)
+
+	ch := make(message.Bus, 100)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return jsonl.New(src, ch, nil, false).Read(gctx)
+	})
+	g.Go(func() error {
+		return file.New(path, ch, nil, false).Write(gctx)
+	})
+	if err := g.Wait(); err == nil {
+		t.Error("failed read should fail the write")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil || string(b) != "previous" {
+		t.Errorf("previous file should be kept, result: %q", b)
+	}
+}
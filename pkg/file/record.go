@@ -0,0 +1,229 @@
+package file
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// magic starts every v2+ Rump file, followed by the version byte.
+// The non-ASCII first byte can't be mistaken for a v1 key.
+const magic = "\x89RUMP\r\n"
+
+// Rump file versions.
+const (
+	version1 = 1
+	version2 = 2
+	version3 = 3
+)
+
+// header is written at the start of new files.
+const header = magic + "\x03"
+
+// Record types.
+const (
+	opRecord = 0x01
+	opFooter = 0xff
+)
+
+// castagnoli is the CRC32 table used for records and footer.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Integrity errors.
+var (
+	errCRC      = errors.New("record checksum mismatch")
+	errNoFooter = errors.New("missing footer, file is truncated or incomplete")
+)
+
+// Manifest is the footer of a v3 file, describing its content.
+// Records and Bytes count the records and the bytes preceding the footer,
+// Digest is the hex SHA-256 of those bytes, uncompressed.
+// Source is the URI the file was dumped from.
+type Manifest struct {
+	Records int64
+	Bytes   int64
+	Source  string
+	Created time.Time
+	Digest  string
+}
+
+// version returns the file version from its header, 1 for legacy files.
+func version(r *bufio.Reader) int {
+	b, err := r.Peek(len(magic) + 1)
+	if err != nil || string(b[:len(magic)]) != magic {
+		return version1
+	}
+
+	return int(b[len(magic)])
+}
+
+// tally counts and hashes the bytes written to it,
+// to create and verify the Manifest.
+type tally struct {
+	h hash.Hash
+	n int64
+}
+
+func newTally() *tally {
+	return &tally{h: sha256.New()}
+}
+
+func (t *tally) Write(b []byte) (int, error) {
+	t.h.Write(b)
+	t.n += int64(len(b))
+	return len(b), nil
+}
+
+func (t *tally) digest() string {
+	return hex.EncodeToString(t.h.Sum(nil))
+}
+
+// writeFields writes an op followed by uvarint length-prefixed fields,
+// and their CRC32 if withCRC.
+func writeFields(w io.Writer, op byte, fields []string, withCRC bool) error {
+	crc := crc32.New(castagnoli)
+	if withCRC {
+		w = io.MultiWriter(w, crc)
+	}
+
+	if _, err := w.Write([]byte{op}); err != nil {
+		return err
+	}
+
+	var l [binary.MaxVarintLen64]byte
+	for _, s := range fields {
+		n := binary.PutUvarint(l[:], uint64(len(s)))
+		if _, err := w.Write(l[:n]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, s); err != nil {
+			return err
+		}
+	}
+
+	if !withCRC {
+		return nil
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// readField reads a uvarint length-prefixed field.
+func readField(r *bufio.Reader) (string, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if l > maxToken {
+		return "", fmt.Errorf("file: field too large, %d bytes", l)
+	}
+
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// readFields reads n fields following an already read op,
+// verifying their CRC32 if withCRC.
+// It returns io.ErrUnexpectedEOF if the file is truncated,
+// and errCRC along with the fields on checksum mismatch.
+func readFields(r *bufio.Reader, op byte, n int, withCRC bool) ([]string, error) {
+	fields := make([]string, n)
+	for i := range fields {
+		var err error
+		fields[i], err = readField(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !withCRC {
+		return fields, nil
+	}
+
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	crc := crc32.New(castagnoli)
+	writeFields(crc, op, fields, false)
+	if crc.Sum32() != binary.BigEndian.Uint32(sum[:]) {
+		return fields, errCRC
+	}
+
+	return fields, nil
+}
+
+// writeRecord writes a Payload as a checksummed record.
+func writeRecord(w io.Writer, p message.Payload) error {
+	return writeFields(w, opRecord, []string{p.Key, p.Value, p.TTL}, true)
+}
+
+// readRecord reads a Payload record following an already read op.
+func readRecord(r *bufio.Reader, withCRC bool) (message.Payload, error) {
+	fields, err := readFields(r, opRecord, 3, withCRC)
+	if fields == nil {
+		return message.Payload{}, err
+	}
+
+	return message.Payload{Key: fields[0], Value: fields[1], TTL: fields[2]}, err
+}
+
+// writeManifest writes the Manifest as the file footer.
+func writeManifest(w io.Writer, m Manifest) error {
+	return writeFields(w, opFooter, []string{
+		strconv.FormatInt(m.Records, 10),
+		strconv.FormatInt(m.Bytes, 10),
+		m.Source,
+		m.Created.Format(time.RFC3339Nano),
+		m.Digest,
+	}, true)
+}
+
+// readManifest reads the file footer following an already read op.
+func readManifest(r *bufio.Reader) (Manifest, error) {
+	var m Manifest
+
+	fields, err := readFields(r, opFooter, 5, true)
+	if err != nil {
+		return m, fmt.Errorf("footer: %v", err)
+	}
+
+	m.Source = fields[2]
+	m.Digest = fields[4]
+	m.Records, err = strconv.ParseInt(fields[0], 10, 64)
+	if err == nil {
+		m.Bytes, err = strconv.ParseInt(fields[1], 10, 64)
+	}
+	if err == nil {
+		m.Created, err = time.Parse(time.RFC3339Nano, fields[3])
+	}
+	if err != nil {
+		return m, fmt.Errorf("footer: %v", err)
+	}
+
+	return m, nil
+}
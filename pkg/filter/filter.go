@@ -0,0 +1,153 @@
+// Package filter selects keys by glob patterns, value types and key lists.
+// Its Stage drops keys from the message bus between a reader and a writer.
+package filter
+
+// Types are the key types selectable with SCAN TYPE.
+var Types = []string{"string", "hash", "list", "set", "zset", "stream"}
+
+// dumpTypes maps the RDB object type, first byte of a DUMP payload,
+// to the key type.
+var dumpTypes = map[byte]string{
+	0:  "string",
+	1:  "list",
+	2:  "set",
+	3:  "zset",
+	4:  "hash",
+	5:  "zset",
+	9:  "hash",
+	10: "list",
+	11: "set",
+	12: "zset",
+	13: "hash",
+	14: "list",
+	15: "stream",
+	16: "hash",
+	17: "zset",
+	18: "list",
+	19: "stream",
+	20: "set",
+	21: "stream",
+	22: "hash",
+	23: "hash",
+	24: "hash",
+	25: "hash",
+}
+
+// DumpType returns the key type of a DUMP payload,
+// or "" if unknown, e.g. for module types.
+func DumpType(dump string) string {
+	if dump == "" {
+		return ""
+	}
+
+	return dumpTypes[dump[0]]
+}
+
+// MatchAny tells if key matches at least one of the glob patterns.
+// No patterns match every key.
+func MatchAny(patterns []string, key string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, p := range patterns {
+		if Glob(p, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Glob matches a key against a pattern using the same rules as Redis:
+// * any sequence, ? any character, [abc] [^abc] [a-z] character classes
+// and \ to escape special characters.
+func Glob(pattern, key string) bool {
+	p, k := 0, 0
+
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			// Collapse consecutive stars.
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for ; k <= len(key); k++ {
+				if Glob(pattern[p+1:], key[k:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if k >= len(key) {
+				return false
+			}
+			k++
+		case '[':
+			if k >= len(key) {
+				return false
+			}
+			var ok bool
+			p, ok = class(pattern, p+1, key[k])
+			if !ok {
+				return false
+			}
+			k++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if k >= len(key) || pattern[p] != key[k] {
+				return false
+			}
+			k++
+		}
+		p++
+	}
+
+	return k == len(key)
+}
+
+// class matches c against the character class starting at pattern[p],
+// right after the opening bracket.
+// It returns the position of the closing bracket.
+func class(pattern string, p int, c byte) (int, bool) {
+	not := p < len(pattern) && pattern[p] == '^'
+	if not {
+		p++
+	}
+
+	match := false
+	for ; p < len(pattern) && pattern[p] != ']'; p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				match = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			start, end := pattern[p], pattern[p+2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				match = true
+			}
+			p += 2
+		case pattern[p] == c:
+			match = true
+		}
+	}
+
+	// Unterminated class, Redis treats end of pattern as closing bracket.
+	if p >= len(pattern) {
+		p = len(pattern) - 1
+	}
+
+	return p, match != not
+}
@@ -0,0 +1,161 @@
+package filter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+func TestGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "session:1", true},
+		{"session:*", "session:1", true},
+		{"session:*", "cart:1", false},
+		{"*:1", "cart:1", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"a**b", "axxb", true},
+		{"exact", "exact", true},
+		{"exact", "exact2", false},
+	}
+
+	for _, c := range cases {
+		if Glob(c.pattern, c.key) != c.match {
+			t.Errorf("pattern %q, key %q: expected %v", c.pattern, c.key, c.match)
+		}
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	if !MatchAny(nil, "key") {
+		t.Error("no patterns should match every key")
+	}
+
+	patterns := []string{"session:*", "cart:*"}
+
+	if !MatchAny(patterns, "cart:1") {
+		t.Error("cart:1 should match")
+	}
+
+	if MatchAny(patterns, "user:1") {
+		t.Error("user:1 should not match")
+	}
+}
+
+func TestDumpType(t *testing.T) {
+	if DumpType("\x00\x05value\x09\x00") != "string" {
+		t.Error("wrong string type")
+	}
+
+	if DumpType("\x10") != "hash" {
+		t.Error("wrong hash type")
+	}
+
+	if DumpType("") != "" {
+		t.Error("empty dump should have no type")
+	}
+}
+
+func TestStage(t *testing.T) {
+	in := make(message.Bus, 10)
+	out := make(message.Bus, 10)
+	s := New(in, out, []string{"pii:*"}, nil, map[string]bool{"secret": true})
+
+	// Dropped keys are released with Drop, never acknowledged as written.
+	var acked, dropped int
+	for _, k := range []string{"pii:1", "secret", "session:1", "cart:1"} {
+		in <- message.Payload{Key: k, Ack: func() { acked++ }, Drop: func() { dropped++ }}
+	}
+	close(in)
+
+	if err := s.Run(context.Background()); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var result []string
+	for p := range out {
+		result = append(result, p.Key)
+	}
+
+	expected := []string{"session:1", "cart:1"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+	if acked != 0 || dropped != 2 {
+		t.Errorf("expected: 2 dropped, result: %d acked, %d dropped", acked, dropped)
+	}
+}
+
+func TestStageAllow(t *testing.T) {
+	s := New(nil, nil, nil, map[string]bool{"session:1": true}, nil)
+
+	if !s.Keep("session:1") {
+		t.Error("allowed key should be kept")
+	}
+
+	if s.Keep("session:2") {
+		t.Error("not allowed key should be dropped")
+	}
+}
+
+func TestStageAllowEmpty(t *testing.T) {
+	f, err := ioutil.TempFile("", "rump-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// An empty allow list, e.g. from a failed export, keeps nothing.
+	allow, err := ReadList(f.Name())
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+	s := New(nil, nil, nil, allow, nil)
+
+	if s.Keep("session:1") {
+		t.Error("empty allow list should drop every key")
+	}
+
+	if !New(nil, nil, nil, nil, nil).Keep("session:1") {
+		t.Error("no allow list should keep every key")
+	}
+}
+
+func TestReadList(t *testing.T) {
+	f, err := ioutil.TempFile("", "rump-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("key1\n\nkey2\r\n")
+	f.Close()
+
+	keys, err := ReadList(f.Name())
+	if err != nil {
+		t.Error("error: ", err)
+	}
+
+	expected := map[string]bool{"key1": true, "key2": true}
+	if !reflect.DeepEqual(expected, keys) {
+		t.Errorf("expected: %v, result: %v", expected, keys)
+	}
+}
@@ -0,0 +1,117 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// Stage drops keys from the message bus, between a reader and a writer.
+// In is the reader Bus, Out the writer Bus.
+// Exclude drops keys matching any glob pattern.
+// Allow, if not nil, only keeps listed keys, none if empty.
+// Deny drops listed keys.
+// Log logs exits, nil to discard them.
+type Stage struct {
+	In      message.Bus
+	Out     message.Bus
+	Exclude []string
+	Allow   map[string]bool
+	Deny    map[string]bool
+	Log     *log.Logger
+}
+
+// New creates the filter Stage.
+func New(in, out message.Bus, exclude []string, allow, deny map[string]bool) *Stage {
+	return &Stage{
+		In:      in,
+		Out:     out,
+		Exclude: exclude,
+		Allow:   allow,
+		Deny:    deny,
+	}
+}
+
+// Keep tells if a key passes all the filters.
+func (s *Stage) Keep(key string) bool {
+	if s.Allow != nil && !s.Allow[key] {
+		return false
+	}
+
+	if s.Deny[key] {
+		return false
+	}
+
+	for _, p := range s.Exclude {
+		if Glob(p, key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Run forwards Payloads from In to Out, dropping filtered keys.
+// Dropped Payloads are released with Drop, as done but not written.
+// Out is closed once In is drained.
+// To be used in an ErrGroup.
+func (s *Stage) Run(ctx context.Context) (err error) {
+	defer s.Out.CloseOnSuccess(&err)
+
+	for s.In != nil {
+		select {
+		// Exit early if context done.
+		case <-ctx.Done():
+			s.Log.Debug("exit", log.Fields{log.Phase: "filter"})
+			return ctx.Err()
+		// Get Messages from Bus
+		case p, ok := <-s.In:
+			// if channel closed, set to nil, break loop
+			if !ok {
+				s.In = nil
+				continue
+			}
+			if !s.Keep(p.Key) {
+				if p.Drop != nil {
+					p.Drop()
+				}
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				s.Log.Debug("exit", log.Fields{log.Phase: "filter"})
+				return ctx.Err()
+			case s.Out <- p:
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadList loads a newline-separated list of keys from a file.
+// Blank lines are ignored, an empty file gives an empty, not nil, list.
+func ReadList(path string) (map[string]bool, error) {
+	d, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	keys := map[string]bool{}
+
+	scanner := bufio.NewScanner(d)
+	for scanner.Scan() {
+		key := strings.TrimRight(scanner.Text(), "\r")
+		if key == "" {
+			continue
+		}
+		keys[key] = true
+	}
+
+	return keys, scanner.Err()
+}
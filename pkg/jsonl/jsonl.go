@@ -0,0 +1,382 @@
+// Package jsonl allows reading/writing from/to a JSON Lines file.
+//
+// Each line holds a key, its type, its value decoded by type and its TTL:
+//
+// {"key":"k","type":"string","value":"v","ttl":1000}
+// {"key":"k","type":"list","value":["a","b"]}
+// {"key":"k","type":"set","value":["a","b"]}
+// {"key":"k","type":"hash","value":{"f":"v"}}
+// {"key":"k","type":"zset","value":[{"member":"a","score":1.5}]}
+// {"key":"k","type":"stream","value":[{"id":"1-0","fields":["f","v"]}]}
+//
+// Lines with strings that aren't valid UTF-8 have every string,
+// key included, base64 encoded and "base64":true.
+package jsonl
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/stickermule/rump/pkg/atomicfile"
+	"github.com/stickermule/rump/pkg/filter"
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/progress"
+	"github.com/stickermule/rump/pkg/rdb"
+	"github.com/stickermule/rump/pkg/resp"
+)
+
+// maxLine is the largest line, same as the Redis max value size.
+const maxLine = 512 << 20
+
+// JSONL can read and write, to a JSON Lines file Path, using the message Bus.
+// Match and Type optionally filter keys on read.
+// Log logs exits and warnings, nil to discard them.
+// Progress counts keys read and written.
+type JSONL struct {
+	Path     string
+	Bus      message.Bus
+	Log      *log.Logger
+	TTL      bool
+	Match    []string
+	Type     string
+	Progress *progress.Progress
+}
+
+// line is a key, as a line of the file.
+type line struct {
+	Key    string          `json:"key"`
+	Type   string          `json:"type"`
+	Value  json.RawMessage `json:"value"`
+	TTL    int64           `json:"ttl,omitempty"`
+	Base64 bool            `json:"base64,omitempty"`
+}
+
+type member struct {
+	Member string `json:"member"`
+	Score  score  `json:"score"`
+}
+
+type entry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// score is a sorted set score, with infinities as "+inf" and "-inf".
+type score float64
+
+func (s score) MarshalJSON() ([]byte, error) {
+	f := float64(s)
+	if math.IsInf(f, 0) {
+		return []byte(`"` + resp.Score(f) + `"`), nil
+	}
+
+	return []byte(resp.Score(f)), nil
+}
+
+func (s *score) UnmarshalJSON(b []byte) error {
+	str := string(b)
+	if len(b) > 1 && b[0] == '"' {
+		str = string(b[1 : len(b)-1])
+	}
+
+	f, err := strconv.ParseFloat(str, 64)
+	*s = score(f)
+	return err
+}
+
+// New creates the JSONL struct, to be used for reading/writing.
+func New(path string, bus message.Bus, logger *log.Logger, ttl bool) *JSONL {
+	return &JSONL{
+		Path: path,
+		Bus:  bus,
+		Log:  logger,
+		TTL:  ttl,
+	}
+}
+
+// Read reads a JSON Lines file and sends Payloads, read by type,
+// to the message bus.
+func (j *JSONL) Read(ctx context.Context) (err error) {
+	defer j.Bus.CloseOnSuccess(&err)
+
+	f, err := os.Open(j.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxLine)
+
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		p, err := unmarshal(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("jsonl: line %d: %v", n, err)
+		}
+
+		if !filter.MatchAny(j.Match, p.Key) {
+			continue
+		}
+		if j.Type != "" && p.Native.Type != j.Type {
+			continue
+		}
+		if !j.TTL {
+			p.TTL = "0"
+		}
+
+		select {
+		case <-ctx.Done():
+			j.Log.Debug("exit", log.Fields{log.Phase: "read"})
+			return ctx.Err()
+		case j.Bus <- p:
+			j.Progress.Read()
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Write writes Payloads from the message bus as JSON Lines.
+// Values read with DUMP are decoded, keys of types without a native
+// form, like modules, are skipped with a warning.
+// Path gets the new lines only once every Payload was written,
+// an interrupted Write keeps the previous export as it was.
+func (j *JSONL) Write(ctx context.Context) error {
+	return atomicfile.Write(ctx, j.Path, func(w io.Writer) error {
+		return j.write(ctx, w)
+	})
+}
+
+// write writes a line for every Payload.
+func (j *JSONL) write(ctx context.Context, f io.Writer) error {
+	// Buffered write to limit system IO calls
+	w := bufio.NewWriter(f)
+
+	for j.Bus != nil {
+		select {
+		// Exit early if context done.
+		case <-ctx.Done():
+			j.Log.Debug("exit", log.Fields{log.Phase: "write"})
+			return ctx.Err()
+		// Get Messages from Bus
+		case p, ok := <-j.Bus:
+			// if channel closed, set to nil, break loop
+			if !ok {
+				j.Bus = nil
+				continue
+			}
+
+			if !j.TTL {
+				p.TTL = "0"
+			}
+
+			b, err := marshal(p)
+			if err == rdb.ErrUnsupported {
+				j.Log.Warn("skipping key", log.Fields{log.Phase: "write", log.Key: p.Key, log.Error: err})
+				j.Progress.Error(1)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("jsonl: key %q: %v", p.Key, err)
+			}
+
+			if _, err := w.Write(append(b, '\n')); err != nil {
+				return err
+			}
+			j.Progress.Written(p.Size())
+		}
+	}
+
+	return w.Flush()
+}
+
+// marshal encodes a Payload as a line, decoding DUMP values.
+func marshal(p message.Payload) ([]byte, error) {
+	var n message.Native
+	if p.Native != nil {
+		n = *p.Native
+	} else {
+		var err error
+		if n, err = rdb.Decode(p.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	l := line{Key: p.Key, Type: n.Type}
+	l.TTL, _ = strconv.ParseInt(p.TTL, 10, 64)
+
+	if !valid(p.Key, n) {
+		l.Base64 = true
+		l.Key = base64.StdEncoding.EncodeToString([]byte(l.Key))
+		n = convert(n, func(s string) (string, error) {
+			return base64.StdEncoding.EncodeToString([]byte(s)), nil
+		})
+	}
+
+	var v interface{}
+	switch n.Type {
+	case "string":
+		v = n.String
+	case "list":
+		v = n.List
+	case "set":
+		v = n.Set
+	case "hash":
+		v = n.Hash
+	case "zset":
+		members := make([]member, len(n.Zset))
+		for i, m := range n.Zset {
+			members[i] = member{m.Member, score(m.Score)}
+		}
+		v = members
+	case "stream":
+		entries := make([]entry, len(n.Stream))
+		for i, e := range n.Stream {
+			entries[i] = entry{e.ID, e.Fields}
+		}
+		v = entries
+	default:
+		return nil, rdb.ErrUnsupported
+	}
+
+	var err error
+	if l.Value, err = json.Marshal(v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(l)
+}
+
+// unmarshal decodes a line into a Payload.
+func unmarshal(b []byte) (message.Payload, error) {
+	var p message.Payload
+	var l line
+	if err := json.Unmarshal(b, &l); err != nil {
+		return p, err
+	}
+
+	n := message.Native{Type: l.Type}
+	var err error
+	switch l.Type {
+	case "string":
+		err = json.Unmarshal(l.Value, &n.String)
+	case "list":
+		err = json.Unmarshal(l.Value, &n.List)
+	case "set":
+		err = json.Unmarshal(l.Value, &n.Set)
+	case "hash":
+		err = json.Unmarshal(l.Value, &n.Hash)
+	case "zset":
+		var members []member
+		err = json.Unmarshal(l.Value, &members)
+		for _, m := range members {
+			n.Zset = append(n.Zset, message.Member{Member: m.Member, Score: float64(m.Score)})
+		}
+	case "stream":
+		var entries []entry
+		err = json.Unmarshal(l.Value, &entries)
+		for _, e := range entries {
+			if len(e.Fields)%2 != 0 {
+				return p, fmt.Errorf("stream entry %s has unpaired fields", e.ID)
+			}
+			n.Stream = append(n.Stream, message.Entry{ID: e.ID, Fields: e.Fields})
+		}
+	default:
+		return p, fmt.Errorf("unknown type %q", l.Type)
+	}
+	if err != nil {
+		return p, err
+	}
+
+	if l.Base64 {
+		key, err := base64.StdEncoding.DecodeString(l.Key)
+		if err != nil {
+			return p, err
+		}
+		l.Key = string(key)
+		n = convert(n, func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		})
+		if n.Type == "" {
+			return p, fmt.Errorf("invalid base64 value")
+		}
+	}
+
+	return message.Payload{
+		Key:    l.Key,
+		TTL:    strconv.FormatInt(l.TTL, 10),
+		Native: &n,
+	}, nil
+}
+
+// valid tells if the key and every string of a value are valid UTF-8,
+// which JSON strings require.
+func valid(key string, n message.Native) bool {
+	ok := utf8.ValidString(key)
+	convert(n, func(s string) (string, error) {
+		ok = ok && utf8.ValidString(s)
+		return s, nil
+	})
+
+	return ok
+}
+
+// convert applies fn to every string of a value, but stream IDs.
+// On error it returns an empty value.
+func convert(n message.Native, fn func(string) (string, error)) message.Native {
+	var err error
+	each := func(s []string) []string {
+		out := make([]string, len(s))
+		for i := range s {
+			var e error
+			if out[i], e = fn(s[i]); e != nil {
+				err = e
+			}
+		}
+		return out
+	}
+
+	c := message.Native{Type: n.Type}
+	if c.String, err = fn(n.String); err != nil {
+		return message.Native{}
+	}
+	if n.List != nil {
+		c.List = each(n.List)
+	}
+	if n.Set != nil {
+		c.Set = each(n.Set)
+	}
+	if n.Hash != nil {
+		c.Hash = make(map[string]string, len(n.Hash))
+		for f, v := range n.Hash {
+			kv := each([]string{f, v})
+			c.Hash[kv[0]] = kv[1]
+		}
+	}
+	for _, m := range n.Zset {
+		m.Member = each([]string{m.Member})[0]
+		c.Zset = append(c.Zset, m)
+	}
+	for _, e := range n.Stream {
+		c.Stream = append(c.Stream, message.Entry{ID: e.ID, Fields: each(e.Fields)})
+	}
+	if err != nil {
+		return message.Native{}
+	}
+
+	return c
+}
@@ -0,0 +1,121 @@
+package jsonl
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// mykey is the DUMP of the integer 10 from the Redis documentation.
+const mykey = "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+
+func TestMarshal(t *testing.T) {
+	cases := []struct {
+		payload  message.Payload
+		expected string
+	}{
+		{
+			message.Payload{Key: "mykey", Value: mykey, TTL: "1000"},
+			`{"key":"mykey","type":"string","value":"10","ttl":1000}`,
+		},
+		{
+			message.Payload{Key: "z", TTL: "0", Native: &message.Native{
+				Type: "zset",
+				Zset: []message.Member{{Member: "a", Score: 1.5}, {Member: "b", Score: math.Inf(1)}},
+			}},
+			`{"key":"z","type":"zset","value":[{"member":"a","score":1.5},{"member":"b","score":"+inf"}]}`,
+		},
+		{
+			message.Payload{Key: "h", TTL: "0", Native: &message.Native{
+				Type: "hash",
+				Hash: map[string]string{"f": "\xff"},
+			}},
+			`{"key":"aA==","type":"hash","value":{"Zg==":"/w=="},"base64":true}`,
+		},
+	}
+
+	for _, c := range cases {
+		b, err := marshal(c.payload)
+		if err != nil || string(b) != c.expected {
+			t.Errorf("error: %v, expected: %s, result: %s", err, c.expected, b)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	f, err := ioutil.TempFile("", "rump*.jsonl")
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	natives := []message.Native{
+		{Type: "string", String: "\x00binary\xff"},
+		{Type: "list", List: []string{"a", "b", "a"}},
+		{Type: "set", Set: []string{"a", "b"}},
+		{Type: "hash", Hash: map[string]string{"f1": "v1", "f2": "v2"}},
+		{Type: "zset", Zset: []message.Member{{Member: "a", Score: math.Inf(-1)}, {Member: "b", Score: 2}}},
+		{Type: "stream", Stream: []message.Entry{{ID: "1-0", Fields: []string{"f", "v"}}}},
+	}
+
+	ch := make(message.Bus, len(natives))
+	for i := range natives {
+		ch <- message.Payload{Key: natives[i].Type, TTL: "1000", Native: &natives[i]}
+	}
+	close(ch)
+
+	target := New(f.Name(), ch, nil, true)
+	if err := target.Write(context.Background()); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	ch = make(message.Bus, len(natives))
+	source := New(f.Name(), ch, nil, true)
+	if err := source.Read(context.Background()); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	i := 0
+	for p := range ch {
+		if p.Key != natives[i].Type || p.TTL != "1000" || !reflect.DeepEqual(*p.Native, natives[i]) {
+			t.Errorf("expected: %s %v, result: %s %s %v", natives[i].Type, natives[i], p.Key, p.TTL, *p.Native)
+		}
+		i++
+	}
+	if i != len(natives) {
+		t.Errorf("expected: %d keys, result: %d", len(natives), i)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "rump*.jsonl")
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"key":"a","type":"string","value":"v"}` + "\n\n" + `{"key":"b","type":"module","value":"v"}` + "\n")
+	f.Close()
+
+	ch := make(message.Bus, 2)
+	source := New(f.Name(), ch, nil, false)
+	err = source.Read(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected an error on line 3, result: %v", err)
+	}
+
+	// The Bus stays open, so writers never take the read as complete.
+	<-ch
+	select {
+	case _, ok := <-ch:
+		t.Errorf("failed read should keep the bus open, closed: %v", !ok)
+	default:
+	}
+}
@@ -0,0 +1,184 @@
+// Package limit throttles reads, to protect busy sources.
+//
+// Buckets cap the rate of keys or bytes read, Backoffs slow reads down
+// while a source exceeds health Thresholds.
+package limit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Bucket is a token bucket, refilled at rate tokens per second,
+// holding up to a second worth of tokens.
+// A nil Bucket is unlimited.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a full Bucket, nil if rate isn't positive.
+func NewBucket(rate int) *Bucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &Bucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// Wait takes n tokens, blocking until the Bucket refilled them or ctx is done.
+// More tokens than the Bucket holds are taken on credit, delaying the
+// following Waits, so large values are limited too.
+func (b *Bucket) Wait(ctx context.Context, n int) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	return sleep(ctx, wait)
+}
+
+// Backoff is an adaptive delay, doubled from Min up to Max while overloaded,
+// and halved once not, down to no delay under Min.
+// A nil Backoff never delays.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+// NewBackoff creates a Backoff, starting with no delay.
+func NewBackoff(min, max time.Duration) *Backoff {
+	return &Backoff{
+		Min: min,
+		Max: max,
+	}
+}
+
+// Report adjusts the delay to the source load, returning it.
+func (b *Backoff) Report(overloaded bool) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case overloaded && b.delay < b.Min:
+		b.delay = b.Min
+	case overloaded:
+		b.delay *= 2
+	default:
+		b.delay /= 2
+	}
+
+	if b.delay > b.Max {
+		b.delay = b.Max
+	}
+	if !overloaded && b.delay < b.Min {
+		b.delay = 0
+	}
+
+	return b.delay
+}
+
+// Delay returns the current delay.
+func (b *Backoff) Delay() time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.delay
+}
+
+// Wait sleeps for the current delay, or until ctx is done.
+func (b *Backoff) Wait(ctx context.Context) error {
+	return sleep(ctx, b.Delay())
+}
+
+// Sample is a source health sample, e.g. from Redis INFO.
+// At is when it was taken, Latency how long it took.
+// CPU is the total CPU time used by the source, in seconds.
+// Ops is the operations per second the source serves.
+type Sample struct {
+	At      time.Time
+	Latency time.Duration
+	CPU     float64
+	Ops     int
+}
+
+// Thresholds of source health, 0 to ignore.
+// Latency is the max Sample Latency.
+// CPU is the max CPU usage between two Samples, in percent of a core.
+// Ops is the max operations per second.
+type Thresholds struct {
+	Latency time.Duration
+	CPU     float64
+	Ops     int
+}
+
+// Enabled tells if any threshold is set.
+func (t Thresholds) Enabled() bool {
+	return t.Latency > 0 || t.CPU > 0 || t.Ops > 0
+}
+
+// Exceeded returns which threshold the cur Sample exceeds,
+// CPU usage being measured since prev, or "" if none.
+func (t Thresholds) Exceeded(prev, cur Sample) string {
+	if t.Latency > 0 && cur.Latency > t.Latency {
+		return fmt.Sprintf("latency %v", cur.Latency)
+	}
+
+	if t.Ops > 0 && cur.Ops > t.Ops {
+		return fmt.Sprintf("%d ops/s", cur.Ops)
+	}
+
+	elapsed := cur.At.Sub(prev.At).Seconds()
+	if t.CPU > 0 && !prev.At.IsZero() && elapsed > 0 {
+		cpu := (cur.CPU - prev.CPU) / elapsed * 100
+		if cpu > t.CPU {
+			return fmt.Sprintf("cpu %.0f%%", cpu)
+		}
+	}
+
+	return ""
+}
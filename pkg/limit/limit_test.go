@@ -0,0 +1,89 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	b := NewBucket(100)
+
+	// A full bucket doesn't wait.
+	start := time.Now()
+	if err := b.Wait(ctx, 100); err != nil || time.Since(start) > 50*time.Millisecond {
+		t.Error("full bucket should not wait")
+	}
+
+	// Taken on credit, 50 tokens take half a second to refill.
+	start = time.Now()
+	if err := b.Wait(ctx, 50); err != nil {
+		t.Error("error: ", err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond || d > 900*time.Millisecond {
+		t.Errorf("expected: 500ms wait, result: %v", d)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := b.Wait(cctx, 1000); err != context.Canceled {
+		t.Error("wait should stop when context is done")
+	}
+}
+
+func TestNilBucket(t *testing.T) {
+	b := NewBucket(0)
+	if b != nil {
+		t.Error("no rate should be unlimited")
+	}
+	if err := b.Wait(context.Background(), 1<<30); err != nil {
+		t.Error("nil bucket should not wait")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff(10*time.Millisecond, 40*time.Millisecond)
+
+	var delays []time.Duration
+	for _, overloaded := range []bool{true, true, true, true, false, false, false} {
+		delays = append(delays, b.Report(overloaded))
+	}
+
+	expected := []time.Duration{10, 20, 40, 40, 20, 10, 0}
+	for i := range expected {
+		if delays[i] != expected[i]*time.Millisecond {
+			t.Errorf("expected: %v, result: %v", expected, delays)
+			break
+		}
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	at := time.Now()
+	prev := Sample{At: at, CPU: 10}
+
+	cases := []struct {
+		thresholds Thresholds
+		sample     Sample
+		exceeded   bool
+	}{
+		{Thresholds{}, Sample{At: at.Add(time.Second), Latency: time.Second, CPU: 20, Ops: 1e6}, false},
+		{Thresholds{Latency: 50 * time.Millisecond}, Sample{At: at.Add(time.Second), Latency: 100 * time.Millisecond}, true},
+		{Thresholds{Latency: 50 * time.Millisecond}, Sample{At: at.Add(time.Second), Latency: time.Millisecond}, false},
+		{Thresholds{Ops: 1000}, Sample{At: at.Add(time.Second), Ops: 5000}, true},
+		{Thresholds{CPU: 80}, Sample{At: at.Add(time.Second), CPU: 10.9}, true},
+		{Thresholds{CPU: 80}, Sample{At: at.Add(time.Second), CPU: 10.5}, false},
+	}
+
+	for _, c := range cases {
+		if exceeded := c.thresholds.Exceeded(prev, c.sample) != ""; exceeded != c.exceeded {
+			t.Errorf("%+v %+v: expected: %v, result: %v", c.thresholds, c.sample, c.exceeded, exceeded)
+		}
+	}
+
+	// CPU usage needs a previous sample.
+	if (Thresholds{CPU: 1}).Exceeded(Sample{}, Sample{At: at, CPU: 1000}) != "" {
+		t.Error("cpu should not be measured from a single sample")
+	}
+}
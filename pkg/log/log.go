@@ -0,0 +1,202 @@
+// Package log is a leveled, structured logger, writing text or JSON lines.
+//
+// Text lines are logfmt, for terminals, without timestamps:
+// level=warn phase=read msg="skipping key" key=k1 source=redis://s
+// JSON lines carry the time, for log pipelines:
+// {"key":"k1","level":"warn","msg":"skipping key","phase":"read","source":"redis://s","time":"..."}
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Levels, from the most verbose.
+// InfoLevel is the zero Level.
+const (
+	DebugLevel Level = iota - 1
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// Levels lists the level names, from the most verbose.
+var Levels = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return Levels[l-DebugLevel]
+}
+
+// ParseLevel parses a level name.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range Levels {
+		if s == name {
+			return DebugLevel + Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %s", s)
+}
+
+// Formats of log lines.
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{Text, JSON}
+
+// Field names shared by every component.
+const (
+	Phase  = "phase"
+	Key    = "key"
+	Source = "source"
+	Target = "target"
+	Error  = "error"
+)
+
+// Fields are structured log fields, by name.
+type Fields map[string]interface{}
+
+// Logger writes entries of at least its level to an io.Writer,
+// as Text or JSON lines.
+// A nil Logger discards every entry.
+type Logger struct {
+	out    io.Writer
+	level  Level
+	format string
+	fields Fields
+
+	// Shared with Loggers derived With fields, so lines never interleave.
+	mu *sync.Mutex
+}
+
+// New creates a Logger, writing Text unless format is JSON.
+func New(out io.Writer, level Level, format string) *Logger {
+	return &Logger{
+		out:    out,
+		level:  level,
+		format: format,
+		mu:     &sync.Mutex{},
+	}
+}
+
+// With returns a Logger adding fields to every entry.
+func (l *Logger) With(fields Fields) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	c := *l
+	c.fields = make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		c.fields[k] = v
+	}
+	for k, v := range fields {
+		c.fields[k] = v
+	}
+
+	return &c
+}
+
+// Enabled tells if entries of level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+// Debug logs a message, e.g. a goroutine exiting.
+func (l *Logger) Debug(msg string, fields Fields) {
+	l.log(DebugLevel, msg, fields)
+}
+
+// Info logs a message, e.g. a phase starting or ending.
+func (l *Logger) Info(msg string, fields Fields) {
+	l.log(InfoLevel, msg, fields)
+}
+
+// Warn logs a message, e.g. a key skipped.
+func (l *Logger) Warn(msg string, fields Fields) {
+	l.log(WarnLevel, msg, fields)
+}
+
+// Error logs a message, e.g. a failed sync.
+func (l *Logger) Error(msg string, fields Fields) {
+	l.log(ErrorLevel, msg, fields)
+}
+
+// log writes an entry, if enabled.
+func (l *Logger) log(level Level, msg string, fields Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	entry := make(Fields, len(l.fields)+len(fields)+3)
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	for k, v := range fields {
+		// Errors don't marshal to JSON.
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+
+	var line []byte
+	if l.format == JSON {
+		entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["msg"] = msg
+		line, _ = json.Marshal(entry)
+	} else {
+		line = []byte(text(level, msg, entry))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+// text formats an entry as logfmt, level, phase and msg first,
+// then the other fields by name.
+func text(level Level, msg string, entry Fields) string {
+	pairs := []string{"level=" + level.String()}
+	if phase, ok := entry[Phase]; ok {
+		pairs = append(pairs, Phase+"="+value(phase))
+		delete(entry, Phase)
+	}
+	pairs = append(pairs, "msg="+value(msg))
+
+	names := make([]string, 0, len(entry))
+	for k := range entry {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		pairs = append(pairs, k+"="+value(entry[k]))
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+// value formats a logfmt value, quoted if needed.
+func value(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\\\t\r\n") || !strconv.CanBackquote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, InfoLevel, Text).With(Fields{Source: "redis://s"})
+
+	l.Debug("exit", Fields{Phase: "read"})
+	l.Warn("skipping key", Fields{Phase: "write", Key: "a key", Error: errors.New("unsupported")})
+	l.Info("done", nil)
+
+	expected := `level=warn phase=write msg="skipping key" error=unsupported key="a key" source=redis://s
+level=info msg=done source=redis://s
+`
+	if out.String() != expected {
+		t.Errorf("expected: %q, result: %q", expected, out.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, DebugLevel, JSON)
+
+	l.Error("sync failed", Fields{Phase: "run", Error: errors.New("boom"), "written": 3})
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	expected := map[string]interface{}{
+		"level":   "error",
+		"msg":     "sync failed",
+		"phase":   "run",
+		"error":   "boom",
+		"written": 3.0,
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("%s expected: %v, result: %v", k, v, entry[k])
+		}
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Error("json entries should have a time")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for i, name := range Levels {
+		level, err := ParseLevel(name)
+		if err != nil || level != DebugLevel+Level(i) || level.String() != name {
+			t.Errorf("%s should parse", name)
+		}
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("unknown levels should not parse")
+	}
+}
+
+func TestNil(t *testing.T) {
+	var l *Logger
+	l.With(Fields{Key: "k"}).Info("discarded", nil)
+	if l.Enabled(ErrorLevel) {
+		t.Error("nil logger should discard entries")
+	}
+}
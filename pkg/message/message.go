@@ -3,11 +3,63 @@
 package message
 
 // Payload represents a Redis key/value pair with TTL.
+// Value is a DUMP payload, unless the key was read by type into Native.
+// Deleted marks a key deleted, or expired, on the source while following.
+// Ack, if set, acknowledges the Payload once written, or Drop once dropped
+// by a stage, to checkpoint the progress of the reader.
 type Payload struct {
-	Key   string
-	Value string
-	TTL   string
+	Key     string
+	Value   string
+	TTL     string
+	Native  *Native
+	Deleted bool
+	Ack     func()
+	Drop    func()
 }
 
+// DefaultBusSize is the default Bus capacity.
+const DefaultBusSize = 100
+
 // Bus is a channel where message Payloads pass.
+// Readers, and stages, close it once everything was sent. After a failed
+// or interrupted read it stays open, writers then stop on their context,
+// never mistaking a partial read for a complete one.
 type Bus chan Payload
+
+// CloseOnSuccess closes the Bus if *err is nil.
+// To be deferred by readers and stages, returning err.
+func (b Bus) CloseOnSuccess(err *error) {
+	if *err == nil {
+		close(b)
+	}
+}
+
+// Size approximates the Payload value size in bytes.
+func (p Payload) Size() int {
+	if p.Native == nil {
+		return len(p.Value)
+	}
+
+	n := p.Native
+	size := len(n.String)
+	for _, s := range n.List {
+		size += len(s)
+	}
+	for _, s := range n.Set {
+		size += len(s)
+	}
+	for f, v := range n.Hash {
+		size += len(f) + len(v)
+	}
+	for _, m := range n.Zset {
+		size += len(m.Member) + 8
+	}
+	for _, e := range n.Stream {
+		size += len(e.ID)
+		for _, s := range e.Fields {
+			size += len(s)
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,26 @@
+package message
+
+// Native is a key value decoded by type, an alternative to DUMP payloads
+// which any Redis version can write with type-specific commands.
+// Only the field matching Type is set.
+type Native struct {
+	Type   string
+	String string
+	List   []string
+	Set    []string
+	Hash   map[string]string
+	Zset   []Member
+	Stream []Entry
+}
+
+// Member is a sorted set member with its score.
+type Member struct {
+	Member string
+	Score  float64
+}
+
+// Entry is a stream entry, with its field/value pairs in order.
+type Entry struct {
+	ID     string
+	Fields []string
+}
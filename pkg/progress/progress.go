@@ -0,0 +1,254 @@
+// Package progress reports the progress of a sync: keys read and written,
+// bytes, throughput, errors and ETA.
+//
+// Progress renders as a bar on terminals, or as periodic log entries.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/stickermule/rump/pkg/log"
+)
+
+// Intervals between renders, on terminals and in logs.
+const (
+	TTYInterval = 500 * time.Millisecond
+	LogInterval = 10 * time.Second
+)
+
+// barWidth is the number of characters of the progress bar.
+const barWidth = 20
+
+// Progress counts keys as they flow, reporting every Interval.
+// Total is the number of keys expected, e.g. from DBSIZE or a file
+// manifest, an upper bound when keys are filtered, 0 if unknown.
+// TTY renders a progress bar to Out, redrawn in place, otherwise
+// progress is logged to Log.
+// A nil Progress counts and renders nothing.
+type Progress struct {
+	// Counters first, for 64-bit atomic alignment.
+	total   int64
+	read    int64
+	written int64
+	bytes   int64
+	deleted int64
+	errors  int64
+
+	Out      io.Writer
+	Log      *log.Logger
+	TTY      bool
+	Interval time.Duration
+
+	start time.Time
+}
+
+// New creates a Progress rendering a bar to out if tty,
+// logging to logger otherwise.
+func New(out io.Writer, logger *log.Logger, tty bool) *Progress {
+	interval := LogInterval
+	if tty {
+		interval = TTYInterval
+	}
+
+	return &Progress{
+		Out:      out,
+		Log:      logger,
+		TTY:      tty,
+		Interval: interval,
+		start:    time.Now(),
+	}
+}
+
+// IsTTY tells if f is a terminal.
+func IsTTY(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// AddTotal adds n keys to the expected Total.
+func (p *Progress) AddTotal(n int64) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.total, n)
+}
+
+// Read counts a key read.
+func (p *Progress) Read() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.read, 1)
+}
+
+// Written counts a key written, of size bytes.
+func (p *Progress) Written(size int) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.written, 1)
+	atomic.AddInt64(&p.bytes, int64(size))
+}
+
+// Deleted counts a key deleted.
+func (p *Progress) Deleted() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.deleted, 1)
+}
+
+// Error counts n failed or skipped keys.
+func (p *Progress) Error(n int) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.errors, int64(n))
+}
+
+// Run reports the progress every Interval until ctx is done.
+// The final counts are left to Fields, logged once every goroutine is done.
+// To be used in an ErrGroup.
+func (p *Progress) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// End the bar line, before the final log entries.
+			if p.TTY {
+				fmt.Fprintln(p.Out)
+			}
+			return nil
+		case now := <-ticker.C:
+			if p.TTY {
+				fmt.Fprint(p.Out, p.render(now))
+			} else {
+				p.Log.Info("progress", p.rates(now))
+			}
+		}
+	}
+}
+
+// Fields returns the counts as log fields, nil for a nil Progress.
+func (p *Progress) Fields() log.Fields {
+	if p == nil {
+		return nil
+	}
+
+	return log.Fields{
+		"total":   atomic.LoadInt64(&p.total),
+		"read":    atomic.LoadInt64(&p.read),
+		"written": atomic.LoadInt64(&p.written),
+		"bytes":   atomic.LoadInt64(&p.bytes),
+		"deleted": atomic.LoadInt64(&p.deleted),
+		"errors":  atomic.LoadInt64(&p.errors),
+	}
+}
+
+// rate returns keys written per second, and the ETA, 0 if unknown.
+func (p *Progress) rate(now time.Time) (float64, time.Duration) {
+	total := atomic.LoadInt64(&p.total)
+	written := atomic.LoadInt64(&p.written)
+
+	var rate float64
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = float64(written) / elapsed
+	}
+
+	var eta time.Duration
+	if total > written && rate > 0 {
+		eta = time.Duration(float64(total-written) / rate * float64(time.Second))
+	}
+
+	return rate, eta.Round(time.Second)
+}
+
+// rates returns the counts as log fields, with throughput and ETA.
+func (p *Progress) rates(now time.Time) log.Fields {
+	fields := p.Fields()
+	fields[log.Phase] = "sync"
+
+	rate, eta := p.rate(now)
+	fields["keys_per_sec"] = int64(rate)
+	if eta > 0 {
+		fields["eta"] = eta.String()
+	}
+
+	return fields
+}
+
+// render returns the progress bar line, with throughput and ETA.
+func (p *Progress) render(now time.Time) string {
+	total := atomic.LoadInt64(&p.total)
+	written := atomic.LoadInt64(&p.written)
+
+	rate, eta := p.rate(now)
+	line := p.counts() + fmt.Sprintf(", %.0f keys/s", rate)
+	if eta > 0 {
+		line += ", ETA " + eta.String()
+	}
+
+	// Redraw the line in place, clearing what's left of the previous one.
+	done := 0
+	if total > 0 {
+		done = int(min(written, total) * barWidth / total)
+	}
+	bar := strings.Repeat("#", done) + strings.Repeat("-", barWidth-done)
+
+	return "\r\x1b[K[" + bar + "] " + line
+}
+
+// counts returns keys written, out of Total, read, deleted,
+// bytes written and errors.
+func (p *Progress) counts() string {
+	total := atomic.LoadInt64(&p.total)
+	read := atomic.LoadInt64(&p.read)
+	written := atomic.LoadInt64(&p.written)
+	deleted := atomic.LoadInt64(&p.deleted)
+
+	var s string
+	if total > 0 {
+		s = fmt.Sprintf("%d/%d keys written (%d%%)", written, total, min(written, total)*100/total)
+	} else {
+		s = fmt.Sprintf("%d keys written", written)
+	}
+
+	s += fmt.Sprintf(", %d read", read)
+	if deleted > 0 {
+		s += fmt.Sprintf(", %d deleted", deleted)
+	}
+
+	return s + fmt.Sprintf(", %s, %d errors", size(atomic.LoadInt64(&p.bytes)), atomic.LoadInt64(&p.errors))
+}
+
+// min returns the smallest of a and b.
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// size formats bytes with a binary unit.
+func size(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
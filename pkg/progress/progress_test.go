@@ -0,0 +1,81 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stickermule/rump/pkg/log"
+)
+
+func TestFields(t *testing.T) {
+	p := New(nil, nil, false)
+	p.AddTotal(4)
+	for i := 0; i < 3; i++ {
+		p.Read()
+		p.Written(1024)
+	}
+	p.Deleted()
+	p.Error(1)
+
+	expected := log.Fields{"total": int64(4), "read": int64(3), "written": int64(3), "bytes": int64(3072), "deleted": int64(1), "errors": int64(1)}
+	if f := p.Fields(); !reflect.DeepEqual(expected, f) {
+		t.Errorf("expected: %v, result: %v", expected, f)
+	}
+
+	expectedCounts := "3/4 keys written (75%), 3 read, 1 deleted, 3.0 KiB, 1 errors"
+	if s := p.counts(); s != expectedCounts {
+		t.Errorf("expected: %q, result: %q", expectedCounts, s)
+	}
+}
+
+func TestRender(t *testing.T) {
+	p := New(nil, nil, true)
+	p.AddTotal(300)
+	for i := 0; i < 100; i++ {
+		p.Written(10)
+	}
+
+	// 100 keys in 2s, 200 left at 50 keys/s.
+	now := p.start.Add(2 * time.Second)
+	expected := "\r\x1b[K[######--------------] 100/300 keys written (33%), 0 read, 1000 B, 0 errors, 50 keys/s, ETA 4s"
+	if s := p.render(now); s != expected {
+		t.Errorf("expected: %q, result: %q", expected, s)
+	}
+
+	f := p.rates(now)
+	if f["keys_per_sec"] != int64(50) || f["eta"] != "4s" {
+		t.Errorf("expected: 50 keys/s, ETA 4s, result: %v", f)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	p := New(nil, log.New(&out, log.InfoLevel, log.Text), false)
+	p.Interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+	if err := p.Run(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	if !strings.HasPrefix(out.String(), "level=info phase=sync msg=progress bytes=0") {
+		t.Errorf("expected: log entries, result: %q", out.String())
+	}
+}
+
+func TestNil(t *testing.T) {
+	var p *Progress
+	p.AddTotal(1)
+	p.Read()
+	p.Written(1)
+	p.Deleted()
+	p.Error(1)
+	if p.Fields() != nil {
+		t.Error("nil progress should have no fields")
+	}
+}
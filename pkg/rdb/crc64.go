@@ -0,0 +1,13 @@
+package rdb
+
+import "hash/crc64"
+
+// jones is the CRC-64/Jones table used by Redis for RDB files
+// and DUMP payloads, in its reversed form.
+var jones = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// checksum updates a Redis CRC64 with b.
+// Redis doesn't invert the CRC before and after, unlike hash/crc64.
+func checksum(crc uint64, b []byte) uint64 {
+	return ^crc64.Update(^crc, jones, b)
+}
@@ -0,0 +1,504 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Opcodes preceding keys or DB sections.
+const (
+	opSlotInfo   = 0xf4
+	opFunction   = 0xf5
+	opModuleAux  = 0xf7
+	opIdle       = 0xf8
+	opFreq       = 0xf9
+	opAux        = 0xfa
+	opResizeDB   = 0xfb
+	opExpireMs   = 0xfc
+	opExpire     = 0xfd
+	opSelectDB   = 0xfe
+	opEOF        = 0xff
+	opModuleEOF  = 0
+	opModuleSInt = 1
+	opModuleUInt = 2
+	opModuleF32  = 3
+	opModuleF64  = 4
+	opModuleStr  = 5
+)
+
+// Value types.
+const (
+	typeString          = 0
+	typeList            = 1
+	typeSet             = 2
+	typeZset            = 3
+	typeHash            = 4
+	typeZset2           = 5
+	typeModule2         = 7
+	typeHashZipmap      = 9
+	typeListZiplist     = 10
+	typeSetIntset       = 11
+	typeZsetZiplist     = 12
+	typeHashZiplist     = 13
+	typeListQuicklist   = 14
+	typeStream          = 15
+	typeHashListpack    = 16
+	typeZsetListpack    = 17
+	typeListQuicklist2  = 18
+	typeStream2         = 19
+	typeSetListpack     = 20
+	typeStream3         = 21
+	typeHashMetadata    = 24
+	typeHashListpackExp = 25
+)
+
+// Length encodings.
+const (
+	len6     = 0
+	len14    = 1
+	len32    = 0x80
+	len64    = 0x81
+	encoded  = 3
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// streamIDLen is the size of a raw stream ID.
+const streamIDLen = 16
+
+var errLZF = errors.New("rdb: invalid lzf string")
+
+// reader reads an RDB file, keeping the CRC64 of the whole file
+// and optionally recording the raw bytes read.
+type reader struct {
+	r   *bufio.Reader
+	crc uint64
+	rec *bytes.Buffer
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r)}
+}
+
+// read reads exactly n bytes.
+func (r *reader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	r.crc = checksum(r.crc, b)
+	if r.rec != nil {
+		r.rec.Write(b)
+	}
+
+	return b, nil
+}
+
+// skip reads and discards n bytes.
+func (r *reader) skip(n int) error {
+	_, err := r.read(n)
+	return err
+}
+
+// byte reads a single byte.
+func (r *reader) byte() (byte, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+// length reads a length, also returning if it's a special string encoding.
+func (r *reader) length() (uint64, bool, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case len6:
+		return uint64(b & 0x3f), false, nil
+	case len14:
+		next, err := r.byte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case encoded:
+		return uint64(b & 0x3f), true, nil
+	}
+
+	switch b {
+	case len32:
+		v, err := r.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(v)), false, nil
+	case len64:
+		v, err := r.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(v), false, nil
+	}
+
+	return 0, false, fmt.Errorf("rdb: unknown length encoding %#x", b)
+}
+
+// count reads a length which can't be a special string encoding.
+func (r *reader) count() (int, error) {
+	l, enc, err := r.length()
+	if err == nil && enc {
+		err = fmt.Errorf("rdb: unexpected string encoding")
+	}
+
+	return int(l), err
+}
+
+// string reads a possibly integer or LZF encoded string.
+func (r *reader) string() (string, error) {
+	l, enc, err := r.length()
+	if err != nil {
+		return "", err
+	}
+
+	if !enc {
+		b, err := r.read(int(l))
+		return string(b), err
+	}
+
+	switch l {
+	case encInt8:
+		b, err := r.read(1)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(b[0]))), nil
+	case encInt16:
+		b, err := r.read(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case encInt32:
+		b, err := r.read(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+	case encLZF:
+		clen, err := r.count()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := r.count()
+		if err != nil {
+			return "", err
+		}
+		b, err := r.read(clen)
+		if err != nil {
+			return "", err
+		}
+		b, err = lzf(b, ulen)
+		return string(b), err
+	}
+
+	return "", fmt.Errorf("rdb: unknown string encoding %#x", l)
+}
+
+// skipStrings reads and discards n strings.
+func (r *reader) skipStrings(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := r.string(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skipDouble reads and discards a legacy string encoded double,
+// a length byte with 253, 254 and 255 meaning NaN, +Inf and -Inf.
+func (r *reader) skipDouble() error {
+	l, err := r.byte()
+	if err != nil || l >= 253 {
+		return err
+	}
+
+	return r.skip(int(l))
+}
+
+// skipModule reads and discards module data, up to its EOF opcode.
+func (r *reader) skipModule() error {
+	for {
+		op, err := r.count()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opModuleEOF:
+			return nil
+		case opModuleSInt, opModuleUInt:
+			_, err = r.count()
+		case opModuleF32:
+			err = r.skip(4)
+		case opModuleF64:
+			err = r.skip(8)
+		case opModuleStr:
+			_, err = r.string()
+		default:
+			err = fmt.Errorf("rdb: unknown module opcode %d", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// skipStream reads and discards a stream value,
+// with its consumer groups and pending entries.
+func (r *reader) skipStream(t byte) error {
+	listpacks, err := r.count()
+	if err != nil {
+		return err
+	}
+	// Master ID and listpack.
+	if err := r.skipStrings(2 * listpacks); err != nil {
+		return err
+	}
+
+	// Length and last ID, then first ID, max deleted ID and
+	// entries added since v2.
+	lengths := 3
+	if t >= typeStream2 {
+		lengths += 5
+	}
+	if err := r.skipCounts(lengths); err != nil {
+		return err
+	}
+
+	groups, err := r.count()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < groups; i++ {
+		if _, err := r.string(); err != nil {
+			return err
+		}
+
+		// Last ID, then entries read since v2.
+		lengths := 2
+		if t >= typeStream2 {
+			lengths++
+		}
+		if err := r.skipCounts(lengths); err != nil {
+			return err
+		}
+
+		pending, err := r.count()
+		if err != nil {
+			return err
+		}
+		for j := 0; j < pending; j++ {
+			// ID and delivery time, then delivery count.
+			if err := r.skip(streamIDLen + 8); err != nil {
+				return err
+			}
+			if _, err := r.count(); err != nil {
+				return err
+			}
+		}
+
+		consumers, err := r.count()
+		if err != nil {
+			return err
+		}
+		for j := 0; j < consumers; j++ {
+			if _, err := r.string(); err != nil {
+				return err
+			}
+
+			// Seen time, then active time since v3.
+			times := 8
+			if t >= typeStream3 {
+				times += 8
+			}
+			if err := r.skip(times); err != nil {
+				return err
+			}
+
+			pending, err := r.count()
+			if err != nil {
+				return err
+			}
+			if err := r.skip(pending * streamIDLen); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// skipCounts reads and discards n lengths.
+func (r *reader) skipCounts(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := r.count(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skipValue reads and discards a value of type t.
+func (r *reader) skipValue(t byte) error {
+	switch t {
+	case typeString, typeHashZipmap, typeListZiplist, typeSetIntset,
+		typeZsetZiplist, typeHashZiplist, typeHashListpack,
+		typeZsetListpack, typeSetListpack:
+		_, err := r.string()
+		return err
+	case typeList, typeSet, typeListQuicklist:
+		n, err := r.count()
+		if err != nil {
+			return err
+		}
+		return r.skipStrings(n)
+	case typeHash:
+		n, err := r.count()
+		if err != nil {
+			return err
+		}
+		return r.skipStrings(2 * n)
+	case typeZset, typeZset2:
+		n, err := r.count()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if _, err := r.string(); err != nil {
+				return err
+			}
+			if t == typeZset2 {
+				err = r.skip(8)
+			} else {
+				err = r.skipDouble()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case typeListQuicklist2:
+		n, err := r.count()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			// Container kind, then node.
+			if _, err := r.count(); err != nil {
+				return err
+			}
+			if _, err := r.string(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case typeHashMetadata:
+		// Min expire time, then ttl, field and value triplets.
+		if err := r.skip(8); err != nil {
+			return err
+		}
+		n, err := r.count()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if _, err := r.count(); err != nil {
+				return err
+			}
+			if err := r.skipStrings(2); err != nil {
+				return err
+			}
+		}
+		return nil
+	case typeHashListpackExp:
+		// Min expire time, then listpack.
+		if err := r.skip(8); err != nil {
+			return err
+		}
+		_, err := r.string()
+		return err
+	case typeModule2:
+		if _, err := r.count(); err != nil {
+			return err
+		}
+		return r.skipModule()
+	case typeStream, typeStream2, typeStream3:
+		return r.skipStream(t)
+	}
+
+	return fmt.Errorf("rdb: unsupported value type %d", t)
+}
+
+// lzf decompresses an LZF compressed string of ulen bytes.
+func lzf(in []byte, ulen int) ([]byte, error) {
+	out := make([]byte, 0, ulen)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		// Literal run of ctrl+1 bytes.
+		if ctrl < 1<<5 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errLZF
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// Back reference of n+2 bytes.
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errLZF
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZF
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errLZF
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != ulen {
+		return nil, errLZF
+	}
+
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// defaultVersion is the RDB version of files without keys,
+// loadable since Redis 5.
+const defaultVersion = 9
+
+// footerLen is the size of the DUMP footer, version and checksum.
+const footerLen = 10
+
+// splitDump verifies a DUMP payload, returning its RDB version
+// and its value, prefixed by the value type.
+func splitDump(dump string) (int, string, error) {
+	if len(dump) <= footerLen {
+		return 0, "", fmt.Errorf("rdb: DUMP payload too short")
+	}
+
+	body := dump[:len(dump)-footerLen]
+	footer := []byte(dump[len(dump)-footerLen:])
+	version := int(binary.LittleEndian.Uint16(footer))
+
+	sum := binary.LittleEndian.Uint64(footer[2:])
+	if checksum(0, []byte(dump[:len(dump)-8])) != sum {
+		return 0, "", fmt.Errorf("rdb: DUMP payload checksum mismatch")
+	}
+
+	return version, body, nil
+}
+
+// writer writes an RDB file, keeping the CRC64 of the whole file.
+type writer struct {
+	w   *bufio.Writer
+	crc uint64
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w)}
+}
+
+// write writes b, updating the checksum.
+func (w *writer) write(b []byte) error {
+	w.crc = checksum(w.crc, b)
+	_, err := w.w.Write(b)
+	return err
+}
+
+// length writes a length, using the shortest encoding.
+func (w *writer) length(l uint64) error {
+	var b []byte
+
+	switch {
+	case l < 1<<6:
+		b = []byte{byte(l)}
+	case l < 1<<14:
+		b = []byte{len14<<6 | byte(l>>8), byte(l)}
+	case l <= 1<<32-1:
+		b = make([]byte, 5)
+		b[0] = len32
+		binary.BigEndian.PutUint32(b[1:], uint32(l))
+	default:
+		b = make([]byte, 9)
+		b[0] = len64
+		binary.BigEndian.PutUint64(b[1:], l)
+	}
+
+	return w.write(b)
+}
+
+// string writes a raw length-prefixed string.
+func (w *writer) string(s string) error {
+	if err := w.length(uint64(len(s))); err != nil {
+		return err
+	}
+
+	return w.write([]byte(s))
+}
+
+// header writes the magic, version, creation time and selects db.
+func (w *writer) header(version, db int) error {
+	if err := w.write([]byte(fmt.Sprintf("%s%04d", magic, version))); err != nil {
+		return err
+	}
+
+	if err := w.write([]byte{opAux}); err != nil {
+		return err
+	}
+	if err := w.string("ctime"); err != nil {
+		return err
+	}
+	if err := w.string(strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+		return err
+	}
+
+	if err := w.write([]byte{opSelectDB}); err != nil {
+		return err
+	}
+
+	return w.length(uint64(db))
+}
+
+// key writes a key, its value prefixed by its type,
+// and its expire time if ttl, in milliseconds, is positive.
+func (w *writer) key(key, value string, ttl int64) error {
+	if ttl > 0 {
+		var b [9]byte
+		b[0] = opExpireMs
+		expire := time.Now().UnixNano()/int64(time.Millisecond) + ttl
+		binary.LittleEndian.PutUint64(b[1:], uint64(expire))
+		if err := w.write(b[:]); err != nil {
+			return err
+		}
+	}
+
+	// Type byte, then key and value.
+	if err := w.write([]byte{value[0]}); err != nil {
+		return err
+	}
+	if err := w.string(key); err != nil {
+		return err
+	}
+
+	return w.write([]byte(value[1:]))
+}
+
+// end writes the EOF opcode and the file checksum, then flushes.
+func (w *writer) end() error {
+	if err := w.write([]byte{opEOF}); err != nil {
+		return err
+	}
+
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], w.crc)
+	if _, err := w.w.Write(sum[:]); err != nil {
+		return err
+	}
+
+	return w.w.Flush()
+}
@@ -0,0 +1,287 @@
+// Package rdb allows reading/writing from/to a standard Redis RDB file.
+//
+// RDB files hold every key with the same value encoding as DUMP payloads,
+// so values are copied verbatim, followed by the DUMP footer: the RDB
+// version and a CRC64 checksum. Keys can then be RESTOREd by pkg/redis.
+// On write the footer is stripped, and files can be loaded by redis-server.
+package rdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/stickermule/rump/pkg/atomicfile"
+	"github.com/stickermule/rump/pkg/filter"
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/progress"
+)
+
+// AllDBs reads keys from every DB of the file, merging them.
+const AllDBs = -1
+
+// magic starts every RDB file, followed by a 4 digits version.
+const magic = "REDIS"
+
+// checksumVersion is the first version with a file checksum.
+const checksumVersion = 5
+
+// RDB can read and write, to an RDB file Path, using the message Bus.
+// Match and Type optionally filter keys on read.
+// DB limits reads to keys of a single DB, 0 by default, or AllDBs.
+// On write, DB is the DB keys are loaded into, 0 for AllDBs.
+// Log logs exits, nil to discard them.
+// Progress counts keys read and written.
+type RDB struct {
+	Path     string
+	Bus      message.Bus
+	Log      *log.Logger
+	TTL      bool
+	Match    []string
+	Type     string
+	DB       int
+	Progress *progress.Progress
+}
+
+// New creates the RDB struct, to be used for reading/writing.
+func New(path string, bus message.Bus, logger *log.Logger, ttl bool) *RDB {
+	return &RDB{
+		Path: path,
+		Bus:  bus,
+		Log:  logger,
+		TTL:  ttl,
+	}
+}
+
+// send pushes a Payload on the Bus, unless filtered out.
+func (d *RDB) send(ctx context.Context, p message.Payload) error {
+	if !filter.MatchAny(d.Match, p.Key) {
+		return nil
+	}
+	if d.Type != "" && filter.DumpType(p.Value) != d.Type {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		d.Log.Debug("exit", log.Fields{log.Phase: "read"})
+		return ctx.Err()
+	case d.Bus <- p:
+		d.Progress.Read()
+	}
+
+	return nil
+}
+
+// Read parses an RDB file and sends its keys, as DUMP payloads,
+// to the message bus.
+// Keys already expired are skipped, as Redis does when loading.
+// The file checksum, if any, is verified once all keys are sent.
+func (d *RDB) Read(ctx context.Context) (err error) {
+	defer d.Bus.CloseOnSuccess(&err)
+
+	f, err := os.Open(d.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := newReader(f)
+
+	header, err := r.read(len(magic) + 4)
+	if err != nil || string(header[:len(magic)]) != magic {
+		return fmt.Errorf("rdb: not an RDB file")
+	}
+	version, err := strconv.Atoi(string(header[len(magic):]))
+	if err != nil {
+		return fmt.Errorf("rdb: invalid version %q", header[len(magic):])
+	}
+
+	// DUMP footer, the version followed by the checksum.
+	var footer [2]byte
+	binary.LittleEndian.PutUint16(footer[:], uint16(version))
+
+	db := 0
+	expire := int64(-1)
+	skipped := map[int]bool{}
+
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opEOF:
+			if version < checksumVersion {
+				return nil
+			}
+			crc := r.crc
+			b, err := r.read(8)
+			if err != nil {
+				return err
+			}
+			// A zero checksum means checksums are disabled.
+			if sum := binary.LittleEndian.Uint64(b); sum != 0 && sum != crc {
+				return fmt.Errorf("rdb: checksum mismatch")
+			}
+			return nil
+		case opSelectDB:
+			db, err = r.count()
+		case opResizeDB:
+			err = r.skipCounts(2)
+		case opAux:
+			err = r.skipStrings(2)
+		case opModuleAux:
+			// Module ID, when opcode and when, then module data.
+			if err = r.skipCounts(3); err == nil {
+				err = r.skipModule()
+			}
+		case opFunction:
+			_, err = r.string()
+		case opSlotInfo:
+			err = r.skipCounts(3)
+		case opIdle:
+			_, err = r.count()
+		case opFreq:
+			_, err = r.byte()
+		case opExpire:
+			var b []byte
+			if b, err = r.read(4); err == nil {
+				expire = int64(binary.LittleEndian.Uint32(b)) * 1000
+			}
+		case opExpireMs:
+			var b []byte
+			if b, err = r.read(8); err == nil {
+				expire = int64(binary.LittleEndian.Uint64(b))
+			}
+		default:
+			var key string
+			key, err = r.string()
+			if err != nil {
+				return err
+			}
+
+			// Record the value, prefixed by its type, as a DUMP payload.
+			value := bytes.NewBuffer([]byte{op})
+			r.rec = value
+			err = r.skipValue(op)
+			r.rec = nil
+			if err != nil {
+				return fmt.Errorf("rdb: key %q: %v", key, err)
+			}
+			value.Write(footer[:])
+			var sum [8]byte
+			binary.LittleEndian.PutUint64(sum[:], checksum(0, value.Bytes()))
+			value.Write(sum[:])
+
+			ttl := int64(0)
+			if expire >= 0 {
+				ttl = expire - time.Now().UnixNano()/int64(time.Millisecond)
+			}
+			expired := expire >= 0 && ttl <= 0
+			expire = -1
+
+			if d.DB != AllDBs && d.DB != db {
+				if !skipped[db] {
+					skipped[db] = true
+					d.Log.Warn("skipping keys of another DB", log.Fields{log.Phase: "read", "db": db})
+				}
+				continue
+			}
+			if expired {
+				continue
+			}
+			if !d.TTL {
+				ttl = 0
+			}
+
+			err = d.send(ctx, message.Payload{
+				Key:   key,
+				Value: value.String(),
+				TTL:   strconv.FormatInt(ttl, 10),
+			})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Write writes Payloads from the message bus to an RDB file.
+// The file has the RDB version of the first DUMP payload, later payloads
+// can't be newer.
+// The whole file, up to its checksum, replaces Path once the Bus is
+// drained, so an interrupted Write leaves a previous RDB file loadable.
+func (d *RDB) Write(ctx context.Context) error {
+	return atomicfile.Write(ctx, d.Path, func(w io.Writer) error {
+		return d.write(ctx, w)
+	})
+}
+
+// write writes the header with the first Payload, then every key.
+func (d *RDB) write(ctx context.Context, f io.Writer) error {
+	w := newWriter(f)
+	version := 0
+
+	db := d.DB
+	if db == AllDBs {
+		db = 0
+	}
+
+	for d.Bus != nil {
+		select {
+		// Exit early if context done.
+		case <-ctx.Done():
+			d.Log.Debug("exit", log.Fields{log.Phase: "write"})
+			return ctx.Err()
+		// Get Messages from Bus
+		case p, ok := <-d.Bus:
+			// if channel closed, set to nil, break loop
+			if !ok {
+				d.Bus = nil
+				continue
+			}
+
+			v, value, err := splitDump(p.Value)
+			if err != nil {
+				return fmt.Errorf("%v, key %q", err, p.Key)
+			}
+
+			if version == 0 {
+				version = v
+				if err := w.header(version, db); err != nil {
+					return err
+				}
+			}
+			if v > version {
+				return fmt.Errorf("rdb: key %q has version %d, newer than file version %d", p.Key, v, version)
+			}
+
+			ttl := int64(0)
+			if d.TTL {
+				ttl, _ = strconv.ParseInt(p.TTL, 10, 64)
+			}
+
+			if err := w.key(p.Key, value, ttl); err != nil {
+				return err
+			}
+			d.Progress.Written(p.Size())
+		}
+	}
+
+	if version == 0 {
+		if err := w.header(defaultVersion, db); err != nil {
+			return err
+		}
+	}
+
+	return w.end()
+}
@@ -0,0 +1,299 @@
+package rdb
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// mykey is the DUMP of the integer 10 from the Redis documentation.
+const mykey = "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+
+// testRDB builds a version 9 RDB file, with a checksum.
+func testRDB(expire int64) []byte {
+	var ms [8]byte
+	binary.LittleEndian.PutUint64(ms[:], uint64(expire))
+
+	b := []byte("REDIS0009")
+	// aux redis-ver 5.0.0
+	b = append(b, "\xfa\x09redis-ver\x055.0.0"...)
+	// select db 0, resize db
+	b = append(b, "\xfe\x00\xfb\x03\x01"...)
+	// integer encoded string, with expire
+	b = append(b, '\xfc')
+	b = append(b, ms[:]...)
+	b = append(b, "\x00\x05mykey\xc0\x0a"...)
+	// LZF compressed key, 24 a's, with a list of 2 items
+	b = append(b, "\x01\xc3\x05\x18\x00a\xe0\x0e\x00\x02\x01x\x01y"...)
+	// zset with a legacy double score and an infinite one
+	b = append(b, "\x03\x04zset\x02\x01a\x011\x01b\xfe"...)
+	// select db 1
+	b = append(b, "\xfe\x01\x00\x03db1\x01x"...)
+	b = append(b, '\xff')
+
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], checksum(0, b))
+	return append(b, sum[:]...)
+}
+
+func read(t *testing.T, b []byte, db int) ([]message.Payload, error) {
+	f, err := ioutil.TempFile("", "rump*.rdb")
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(b)
+	f.Close()
+
+	ch := make(message.Bus, 100)
+	source := New(f.Name(), ch, nil, true)
+	source.DB = db
+	if err := source.Read(context.Background()); err != nil {
+		// Failed reads keep the Bus open.
+		return nil, err
+	}
+
+	var result []message.Payload
+	for p := range ch {
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+func TestChecksum(t *testing.T) {
+	if sum := checksum(0, []byte("123456789")); sum != 0xe9c6d914c4b8d9ca {
+		t.Errorf("expected: %x, result: %x", uint64(0xe9c6d914c4b8d9ca), sum)
+	}
+}
+
+func TestLZF(t *testing.T) {
+	b, err := lzf([]byte("\x00a\xe0\x0e\x00"), 24)
+	if err != nil || string(b) != "aaaaaaaaaaaaaaaaaaaaaaaa" {
+		t.Errorf("error: %v, result: %q", err, b)
+	}
+
+	if _, err := lzf([]byte("\x00a\xe0\x0e\x05"), 24); err == nil {
+		t.Error("invalid back reference should not work")
+	}
+}
+
+func TestRead(t *testing.T) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	result, err := read(t, testRDB(now+60000), AllDBs)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	var keys []string
+	for _, p := range result {
+		keys = append(keys, p.Key)
+	}
+	expected := []string{"mykey", "aaaaaaaaaaaaaaaaaaaaaaaa", "zset", "db1"}
+	if !reflect.DeepEqual(expected, keys) {
+		t.Errorf("expected: %v, result: %v", expected, keys)
+	}
+
+	if result[0].Value != mykey {
+		t.Errorf("expected: %q, result: %q", mykey, result[0].Value)
+	}
+	if ttl, _ := strconv.Atoi(result[0].TTL); ttl <= 0 || ttl > 60000 {
+		t.Errorf("expected ttl up to 60000, result: %v", result[0].TTL)
+	}
+	if result[1].TTL != "0" {
+		t.Errorf("expected no ttl, result: %v", result[1].TTL)
+	}
+}
+
+func TestReadDB(t *testing.T) {
+	if db := New("dump.rdb", nil, nil, false).DB; db != 0 {
+		t.Errorf("expected: DB 0 by default, result: %d", db)
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	result, err := read(t, testRDB(now-1000), 0)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	// mykey is expired, db1 is filtered out.
+	if len(result) != 2 || result[0].Key != "aaaaaaaaaaaaaaaaaaaaaaaa" || result[1].Key != "zset" {
+		t.Errorf("expected 2 db 0 keys, result: %v", result)
+	}
+}
+
+func TestReadChecksum(t *testing.T) {
+	b := testRDB(0)
+	b[len(b)-1]++
+
+	if _, err := read(t, b, AllDBs); err == nil {
+		t.Error("checksum mismatch should not work")
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	payloads, err := read(t, testRDB(now+60000), AllDBs)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	f, err := ioutil.TempFile("", "rump*.rdb")
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	ch := make(message.Bus, 100)
+	for _, p := range payloads {
+		ch <- p
+	}
+	close(ch)
+
+	target := New(f.Name(), ch, nil, true)
+	target.DB = 3
+	if err := target.Write(context.Background()); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	result, err := read(t, b, 3)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	if len(result) != len(payloads) {
+		t.Fatalf("expected: %v, result: %v", payloads, result)
+	}
+	for i := range payloads {
+		if payloads[i].Key != result[i].Key || payloads[i].Value != result[i].Value {
+			t.Errorf("expected: %v, result: %v", payloads[i], result[i])
+		}
+	}
+	if ttl, _ := strconv.Atoi(result[0].TTL); ttl <= 0 || ttl > 60000 {
+		t.Errorf("expected ttl up to 60000, result: %v", result[0].TTL)
+	}
+}
+
+func TestWriteInvalid(t *testing.T) {
+	ch := make(message.Bus, 1)
+	ch <- message.Payload{Key: "mykey", Value: mykey[:len(mykey)-1] + "x", TTL: "0"}
+	close(ch)
+
+	path := os.TempDir() + "/rump-invalid.rdb"
+	target := New(path, ch, nil, false)
+	if err := target.Write(context.Background()); err == nil {
+		t.Error("invalid DUMP payload should not work")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("failed write should not create the file")
+	}
+}
+
+// dump appends a version 9 DUMP footer to a value.
+func dump(value string) string {
+	b := append([]byte(value), 9, 0)
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], checksum(0, b))
+	return string(append(b, sum[:]...))
+}
+
+func TestPacked(t *testing.T) {
+	cases := []struct {
+		decode   func([]byte) ([]string, error)
+		b        string
+		expected []string
+	}{
+		{listpack, "\x00\x00\x00\x00\x00\x00\x81a\x02\x05\x01\xdf\xff\x02\xff", []string{"a", "5", "-1"}},
+		{ziplist, "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01a\x03\xf2\x02\xfe\xff\xff", []string{"a", "1", "-1"}},
+		{intset, "\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00\xff\xff", []string{"1", "-1"}},
+		{zipmap, "\x01\x01f\x01\x00v\xff", []string{"f", "v"}},
+	}
+
+	for _, c := range cases {
+		result, err := c.decode([]byte(c.b))
+		if err != nil || !reflect.DeepEqual(c.expected, result) {
+			t.Errorf("%q error: %v, expected: %v, result: %v", c.b, err, c.expected, result)
+		}
+	}
+
+	if _, err := listpack([]byte("\x00\x00\x00\x00\x00\x00\x81")); err == nil {
+		t.Error("truncated listpack should not work")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		dump     string
+		expected message.Native
+	}{
+		{mykey, message.Native{Type: "string", String: "10"}},
+		{dump("\x01\x02\x01x\x01y"), message.Native{Type: "list", List: []string{"x", "y"}}},
+		{dump("\x0b\x0c\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00\xff\xff"), message.Native{Type: "set", Set: []string{"1", "-1"}}},
+		{dump("\x04\x01\x01f\x01v"), message.Native{Type: "hash", Hash: map[string]string{"f": "v"}}},
+		{dump("\x03\x02\x01b\xfe\x01a\x011"), message.Native{Type: "zset", Zset: []message.Member{{Member: "a", Score: 1}, {Member: "b", Score: math.Inf(1)}}}},
+	}
+
+	for _, c := range cases {
+		result, err := Decode(c.dump)
+		if err != nil || !reflect.DeepEqual(c.expected, result) {
+			t.Errorf("%q error: %v, expected: %v, result: %v", c.dump, err, c.expected, result)
+		}
+	}
+
+	if _, err := Decode(dump("\x07\x00")); err != ErrUnsupported {
+		t.Error("module values should be unsupported")
+	}
+}
+
+// lp builds a listpack of small integers and short strings.
+func lp(items ...interface{}) string {
+	b := []byte("\x00\x00\x00\x00\x00\x00")
+	for _, item := range items {
+		switch v := item.(type) {
+		case int:
+			b = append(b, byte(v), 1)
+		case string:
+			b = append(b, 0x80|byte(len(v)))
+			b = append(b, v...)
+			b = append(b, byte(1+len(v)))
+		}
+	}
+
+	return string(append(b, 0xff))
+}
+
+func TestDecodeStream(t *testing.T) {
+	// Master entry with field f, then an entry with the same fields,
+	// a deleted one, and one with its own fields.
+	node := lp(
+		3, 1, 1, "f", 0,
+		2, 0, 0, "v1", 4,
+		3, 0, 1, "v2", 4,
+		0, 1, 0, 1, "g", "v3", 6,
+	)
+	master := "\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00\x00\x00\x00\x00\x00"
+	value := "\x0f\x01\x10" + master + string(byte(len(node))) + node
+
+	result, err := Decode(dump(value))
+	expected := message.Native{Type: "stream", Stream: []message.Entry{
+		{ID: "5-0", Fields: []string{"f", "v1"}},
+		{ID: "6-0", Fields: []string{"g", "v3"}},
+	}}
+	if err != nil || !reflect.DeepEqual(expected, result) {
+		t.Errorf("error: %v, expected: %v, result: %v", err, expected, result)
+	}
+}
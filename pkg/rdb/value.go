@@ -0,0 +1,637 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// ErrUnsupported is returned decoding values without a native form,
+// like module types and hashes with field expiration.
+var ErrUnsupported = errors.New("rdb: value type can't be decoded")
+
+// Quicklist 2 node containers.
+const (
+	containerPlain  = 1
+	containerPacked = 2
+)
+
+// Stream entry flags.
+const (
+	entryDeleted    = 1
+	entrySameFields = 2
+)
+
+var errEncoding = errors.New("rdb: invalid value encoding")
+
+// Decode decodes a DUMP payload into a Native value.
+func Decode(dump string) (message.Native, error) {
+	var n message.Native
+
+	_, body, err := splitDump(dump)
+	if err != nil {
+		return n, err
+	}
+
+	r := newReader(strings.NewReader(body[1:]))
+	t := body[0]
+
+	switch t {
+	case typeString:
+		n.Type = "string"
+		n.String, err = r.string()
+	case typeList:
+		n.Type = "list"
+		n.List, err = r.strings()
+	case typeListZiplist:
+		n.Type = "list"
+		n.List, err = r.packed(ziplist)
+	case typeListQuicklist:
+		n.Type = "list"
+		n.List, err = r.quicklist(false)
+	case typeListQuicklist2:
+		n.Type = "list"
+		n.List, err = r.quicklist(true)
+	case typeSet:
+		n.Type = "set"
+		n.Set, err = r.strings()
+	case typeSetIntset:
+		n.Type = "set"
+		n.Set, err = r.packed(intset)
+	case typeSetListpack:
+		n.Type = "set"
+		n.Set, err = r.packed(listpack)
+	case typeHash:
+		n.Type = "hash"
+		var pairs []string
+		var l int
+		if l, err = r.count(); err == nil {
+			pairs, err = r.stringsN(2 * l)
+		}
+		n.Hash, err = hash(pairs, err)
+	case typeHashZipmap, typeHashZiplist, typeHashListpack:
+		n.Type = "hash"
+		decode := map[byte]func([]byte) ([]string, error){
+			typeHashZipmap:   zipmap,
+			typeHashZiplist:  ziplist,
+			typeHashListpack: listpack,
+		}[t]
+		n.Hash, err = hash(r.packed(decode))
+	case typeZset, typeZset2:
+		n.Type = "zset"
+		n.Zset, err = r.zset(t == typeZset2)
+	case typeZsetZiplist, typeZsetListpack:
+		n.Type = "zset"
+		decode := ziplist
+		if t == typeZsetListpack {
+			decode = listpack
+		}
+		n.Zset, err = members(r.packed(decode))
+	case typeStream, typeStream2, typeStream3:
+		n.Type = "stream"
+		n.Stream, err = r.stream()
+	default:
+		return n, ErrUnsupported
+	}
+
+	// Members ordered like ZRANGE.
+	sort.SliceStable(n.Zset, func(i, j int) bool {
+		a, b := n.Zset[i], n.Zset[j]
+		return a.Score < b.Score || (a.Score == b.Score && a.Member < b.Member)
+	})
+
+	return n, err
+}
+
+// strings reads a length-prefixed list of strings.
+func (r *reader) strings() ([]string, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.stringsN(n)
+}
+
+// stringsN reads n strings.
+func (r *reader) stringsN(n int) ([]string, error) {
+	s := make([]string, n)
+	for i := range s {
+		var err error
+		if s[i], err = r.string(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// packed reads a string holding a ziplist, listpack, intset or zipmap,
+// and decodes its elements.
+func (r *reader) packed(decode func([]byte) ([]string, error)) ([]string, error) {
+	b, err := r.string()
+	if err != nil {
+		return nil, err
+	}
+
+	return decode([]byte(b))
+}
+
+// quicklist reads a list of ziplist nodes, or of plain and
+// listpack nodes since v2.
+func (r *reader) quicklist(v2 bool) ([]string, error) {
+	nodes, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	for i := 0; i < nodes; i++ {
+		container := containerPacked
+		if v2 {
+			if container, err = r.count(); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+
+		if container == containerPlain {
+			items = append(items, b)
+			continue
+		}
+
+		decode := ziplist
+		if v2 {
+			decode = listpack
+		}
+		node, err := decode([]byte(b))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, node...)
+	}
+
+	return items, nil
+}
+
+// zset reads members with string encoded scores, or binary since v2.
+func (r *reader) zset(v2 bool) ([]message.Member, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+
+	zset := make([]message.Member, n)
+	for i := range zset {
+		if zset[i].Member, err = r.string(); err != nil {
+			return nil, err
+		}
+
+		if v2 {
+			b, err := r.read(8)
+			if err != nil {
+				return nil, err
+			}
+			zset[i].Score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			continue
+		}
+
+		l, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch l {
+		case 253:
+			zset[i].Score = math.NaN()
+		case 254:
+			zset[i].Score = math.Inf(1)
+		case 255:
+			zset[i].Score = math.Inf(-1)
+		default:
+			b, err := r.read(int(l))
+			if err != nil {
+				return nil, err
+			}
+			if zset[i].Score, err = strconv.ParseFloat(string(b), 64); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return zset, nil
+}
+
+// stream reads stream entries, skipping deleted ones.
+// Consumer groups are read but not decoded.
+func (r *reader) stream() ([]message.Entry, error) {
+	listpacks, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []message.Entry
+	for i := 0; i < listpacks; i++ {
+		master, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		if len(master) != streamIDLen {
+			return nil, errEncoding
+		}
+		ms := binary.BigEndian.Uint64([]byte(master[:8]))
+		seq := binary.BigEndian.Uint64([]byte(master[8:]))
+
+		lp, err := r.packed(listpack)
+		if err != nil {
+			return nil, err
+		}
+		node, err := streamNode(lp, ms, seq)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, node...)
+	}
+
+	return entries, nil
+}
+
+// streamNode decodes the entries of a stream listpack,
+// with IDs relative to the master ID ms-seq.
+func streamNode(lp []string, ms, seq uint64) ([]message.Entry, error) {
+	next := func(n int) ([]string, error) {
+		if len(lp) < n {
+			return nil, errEncoding
+		}
+		s := lp[:n]
+		lp = lp[n:]
+		return s, nil
+	}
+	num := func() (int, error) {
+		s, err := next(1)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(s[0])
+	}
+
+	// Count, deleted, then master fields and terminator.
+	if _, err := next(2); err != nil {
+		return nil, err
+	}
+	nfields, err := num()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := next(nfields)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := next(1); err != nil {
+		return nil, err
+	}
+
+	var entries []message.Entry
+	for len(lp) > 0 {
+		flags, err := num()
+		if err != nil {
+			return nil, err
+		}
+		id, err := next(2)
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err1 := strconv.ParseUint(id[0], 10, 64)
+		seqDiff, err2 := strconv.ParseUint(id[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, errEncoding
+		}
+
+		var pairs []string
+		if flags&entrySameFields != 0 {
+			values, err := next(nfields)
+			if err != nil {
+				return nil, err
+			}
+			for j := range fields {
+				pairs = append(pairs, fields[j], values[j])
+			}
+		} else {
+			n, err := num()
+			if err != nil {
+				return nil, err
+			}
+			if pairs, err = next(2 * n); err != nil {
+				return nil, err
+			}
+		}
+
+		// Entry count, for backward iteration.
+		if _, err := next(1); err != nil {
+			return nil, err
+		}
+
+		if flags&entryDeleted != 0 {
+			continue
+		}
+		entries = append(entries, message.Entry{
+			ID:     fmt.Sprintf("%d-%d", ms+msDiff, seq+seqDiff),
+			Fields: pairs,
+		})
+	}
+
+	return entries, nil
+}
+
+// hash pairs fields and values.
+func hash(pairs []string, err error) (map[string]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs)%2 != 0 {
+		return nil, errEncoding
+	}
+
+	h := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		h[pairs[i]] = pairs[i+1]
+	}
+
+	return h, nil
+}
+
+// members pairs members and scores.
+func members(pairs []string, err error) ([]message.Member, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs)%2 != 0 {
+		return nil, errEncoding
+	}
+
+	zset := make([]message.Member, len(pairs)/2)
+	for i := range zset {
+		zset[i].Member = pairs[2*i]
+		if zset[i].Score, err = strconv.ParseFloat(pairs[2*i+1], 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return zset, nil
+}
+
+// ziplist decodes the elements of a ziplist.
+func ziplist(b []byte) ([]string, error) {
+	// Total bytes, tail offset and count.
+	const header = 10
+	if len(b) < header+1 {
+		return nil, errEncoding
+	}
+
+	var items []string
+	for i := header; ; {
+		if i >= len(b) {
+			return nil, errEncoding
+		}
+		if b[i] == 0xff {
+			return items, nil
+		}
+
+		// Previous entry length.
+		if b[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(b) {
+			return nil, errEncoding
+		}
+
+		enc := b[i]
+		var l, n int
+		var v int64
+		switch {
+		case enc>>6 == 0:
+			l, n = int(enc&0x3f), 1
+		case enc>>6 == 1:
+			if i+2 > len(b) {
+				return nil, errEncoding
+			}
+			l, n = int(enc&0x3f)<<8|int(b[i+1]), 2
+		case enc == 0x80:
+			if i+5 > len(b) {
+				return nil, errEncoding
+			}
+			l, n = int(binary.BigEndian.Uint32(b[i+1:])), 5
+		default:
+			var size int
+			switch enc {
+			case 0xc0:
+				size = 2
+			case 0xd0:
+				size = 4
+			case 0xe0:
+				size = 8
+			case 0xf0:
+				size = 3
+			case 0xfe:
+				size = 1
+			default:
+				if enc < 0xf1 || enc > 0xfd {
+					return nil, errEncoding
+				}
+				v = int64(enc&0x0f) - 1
+			}
+			if i+1+size > len(b) {
+				return nil, errEncoding
+			}
+			if size > 0 {
+				v = littleInt(b[i+1 : i+1+size])
+			}
+			items = append(items, strconv.FormatInt(v, 10))
+			i += 1 + size
+			continue
+		}
+
+		if i+n+l > len(b) {
+			return nil, errEncoding
+		}
+		items = append(items, string(b[i+n:i+n+l]))
+		i += n + l
+	}
+}
+
+// listpack decodes the elements of a listpack.
+func listpack(b []byte) ([]string, error) {
+	// Total bytes and count.
+	const header = 6
+	if len(b) < header+1 {
+		return nil, errEncoding
+	}
+
+	var items []string
+	for i := header; ; {
+		if i >= len(b) {
+			return nil, errEncoding
+		}
+
+		enc := b[i]
+		var n, l int
+		item := ""
+		isInt := true
+		var v int64
+
+		switch {
+		case enc == 0xff:
+			return items, nil
+		case enc>>7 == 0:
+			n, v = 1, int64(enc)
+		case enc>>6 == 2:
+			n, l, isInt = 1, int(enc&0x3f), false
+		case enc>>5 == 6:
+			if i+2 > len(b) {
+				return nil, errEncoding
+			}
+			n = 2
+			v = int64(uint64(enc&0x1f)<<8 | uint64(b[i+1]))
+			// Sign extend 13 bits.
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+		case enc>>4 == 0xe:
+			if i+2 > len(b) {
+				return nil, errEncoding
+			}
+			n, l, isInt = 2, int(enc&0x0f)<<8|int(b[i+1]), false
+		case enc == 0xf0:
+			if i+5 > len(b) {
+				return nil, errEncoding
+			}
+			n, l, isInt = 5, int(binary.LittleEndian.Uint32(b[i+1:])), false
+		case enc >= 0xf1 && enc <= 0xf4:
+			size := map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[enc]
+			if i+1+size > len(b) {
+				return nil, errEncoding
+			}
+			n, v = 1+size, littleInt(b[i+1:i+1+size])
+		default:
+			return nil, errEncoding
+		}
+
+		if i+n+l > len(b) {
+			return nil, errEncoding
+		}
+		if isInt {
+			item = strconv.FormatInt(v, 10)
+		} else {
+			item = string(b[i+n : i+n+l])
+		}
+		items = append(items, item)
+
+		i += n + l + backlen(n+l)
+	}
+}
+
+// backlen returns the size of a listpack entry back length.
+func backlen(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+
+	return 5
+}
+
+// littleInt decodes a signed little endian integer of up to 8 bytes.
+func littleInt(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+
+	// Sign extend.
+	shift := uint(64 - 8*len(b))
+	return int64(u<<shift) >> shift
+}
+
+// intset decodes the integers of an intset.
+func intset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errEncoding
+	}
+
+	size := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (size != 2 && size != 4 && size != 8) || len(b) < 8+n*size {
+		return nil, errEncoding
+	}
+
+	items := make([]string, n)
+	for i := range items {
+		items[i] = strconv.FormatInt(littleInt(b[8+i*size:8+(i+1)*size]), 10)
+	}
+
+	return items, nil
+}
+
+// zipmap decodes the keys and values of a zipmap.
+func zipmap(b []byte) ([]string, error) {
+	var items []string
+
+	// Count, unreliable above 253.
+	i := 1
+	length := func() (int, error) {
+		if i >= len(b) {
+			return 0, errEncoding
+		}
+		if b[i] < 254 {
+			i++
+			return int(b[i-1]), nil
+		}
+		if b[i] == 0xff || i+5 > len(b) {
+			return 0, errEncoding
+		}
+		i += 5
+		return int(binary.LittleEndian.Uint32(b[i-4:])), nil
+	}
+
+	for {
+		if i >= len(b) {
+			return nil, errEncoding
+		}
+		if b[i] == 0xff {
+			return items, nil
+		}
+
+		l, err := length()
+		if err != nil || i+l > len(b) {
+			return nil, errEncoding
+		}
+		items = append(items, string(b[i:i+l]))
+		i += l
+
+		if l, err = length(); err != nil || i+1+l > len(b) {
+			return nil, errEncoding
+		}
+		// Free bytes after the value.
+		free := int(b[i])
+		i++
+		items = append(items, string(b[i:i+l]))
+		i += l + free
+	}
+}
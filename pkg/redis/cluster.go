@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+
+	"github.com/mediocregopher/radix/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultPort is used for Cluster seed nodes without an explicit port.
+const defaultPort = "6379"
+
+// NewCluster connects to a Redis Cluster from a redis+cluster:// URI.
+// The URI lists one or more seed nodes, e.g.
+// redis+cluster://:password@10.0.0.1:6379,10.0.0.2
+// The remaining nodes are discovered via CLUSTER SLOTS.
+// size is the number of pooled connections per node.
+// tlsConfig is required for rediss+cluster://, nil otherwise.
+func NewCluster(uri string, size int, tlsConfig *tls.Config) (*radix.Cluster, error) {
+	u, addrs, err := parseCluster(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	d := Dialer{TLS: tlsConfig}
+	d.Password, _ = u.User.Password()
+
+	// Each node gets its own pool, authenticating if required.
+	poolFunc := func(network, addr string) (radix.Client, error) {
+		return radix.NewPool(network, addr, size, radix.PoolConnFunc(d.Dial))
+	}
+
+	return radix.NewCluster(addrs, radix.ClusterPoolFunc(poolFunc))
+}
+
+// parseCluster parses a redis+cluster:// URI, returning its seed nodes,
+// on defaultPort unless given.
+func parseCluster(uri string) (*url.URL, []string, error) {
+	u, hosts, err := parseHosts(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Redis Cluster only supports DB 0.
+	if u.Path != "" && u.Path != "/" && u.Path != "/0" {
+		return nil, nil, fmt.Errorf("cluster: only db 0 is supported, got %s", u.Path)
+	}
+
+	if len(hosts) == 0 {
+		return nil, nil, fmt.Errorf("cluster: no seed nodes in %s", uri)
+	}
+
+	return u, withPort(hosts, defaultPort), nil
+}
+
+// readCluster scans every Cluster primary in parallel.
+// Keys are dumped from the node being scanned, which owns them.
+func (r *Redis) readCluster(ctx context.Context, c *radix.Cluster) error {
+	g, gctx := errgroup.WithContext(ctx)
+
+	for _, node := range c.Topo().Primaries() {
+		node := node
+		db, err := c.Client(node.Addr)
+		if err != nil {
+			return err
+		}
+
+		g.Go(func() error {
+			return r.readNode(gctx, db, node.Addr)
+		})
+	}
+
+	return g.Wait()
+}
@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCluster(t *testing.T) {
+	u, seeds, err := parseCluster("redis+cluster://:password@10.0.0.1:7000,10.0.0.2/0")
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	expected := []string{"10.0.0.1:7000", "10.0.0.2:6379"}
+	if !reflect.DeepEqual(expected, seeds) {
+		t.Errorf("expected: %v, result: %v", expected, seeds)
+	}
+	if password, _ := u.User.Password(); password != "password" {
+		t.Errorf("expected: password, result: %v", u)
+	}
+
+	_, seeds, err = parseCluster("redis+cluster://10.0.0.1,10.0.0.2")
+	expected = []string{"10.0.0.1:6379", "10.0.0.2:6379"}
+	if err != nil || !reflect.DeepEqual(expected, seeds) {
+		t.Errorf("expected: %v, result: %v, %v", expected, seeds, err)
+	}
+
+	if _, _, err := parseCluster("redis+cluster://10.0.0.1/1"); err == nil {
+		t.Error("db other than 0 should not work")
+	}
+	if _, _, err := parseCluster("redis+cluster:///0"); err == nil {
+		t.Error("no seed nodes should not work")
+	}
+}
@@ -0,0 +1,227 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// timeout applies to connect, read and write, same as radix defaults.
+const timeout = 10 * time.Second
+
+// Dialer opens authenticated connections to Redis nodes.
+// TLS enables encrypted connections when not nil.
+// DB is selected after connecting, unless 0.
+type Dialer struct {
+	TLS      *tls.Config
+	Password string
+	DB       int
+}
+
+// timeoutConn sets a deadline on every read and write.
+type timeoutConn struct {
+	net.Conn
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	return c.Conn.Write(b)
+}
+
+// Dial is a radix.ConnFunc, used by pools, clusters and sentinels.
+func (d Dialer) Dial(network, addr string) (radix.Conn, error) {
+	if d.TLS == nil {
+		opts := []radix.DialOpt{radix.DialAuthPass(d.Password)}
+		if d.DB != 0 {
+			opts = append(opts, radix.DialSelectDB(d.DB))
+		}
+		return radix.Dial(network, addr, opts...)
+	}
+
+	// Verify the certificate against the host we dial, unless overridden.
+	cfg := d.TLS.Clone()
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: timeout}
+	netConn, err := tls.DialWithDialer(dialer, network, addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := radix.NewConn(&timeoutConn{netConn})
+
+	if d.Password != "" {
+		if err := conn.Do(radix.Cmd(nil, "AUTH", d.Password)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if d.DB != 0 {
+		if err := conn.Do(radix.Cmd(nil, "SELECT", strconv.Itoa(d.DB))); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// NewPool connects to a single Redis node from a redis:// or rediss:// URI.
+// size is the number of pooled connections.
+// tlsConfig is required for rediss://, nil otherwise.
+func NewPool(uri string, size int, tlsConfig *tls.Config) (*radix.Pool, error) {
+	// Plain URIs are parsed by radix itself.
+	if tlsConfig == nil {
+		return radix.NewPool("tcp", uri, size)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	d := Dialer{TLS: tlsConfig}
+	d.Password, _ = u.User.Password()
+	if d.DB, err = DB(uri); err != nil {
+		return nil, err
+	}
+
+	return radix.NewPool("tcp", u.Host, size, radix.PoolConnFunc(d.Dial))
+}
+
+// withPort adds port to hosts without an explicit one.
+func withPort(hosts []string, port string) []string {
+	addrs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, port)
+		}
+		addrs = append(addrs, host)
+	}
+
+	return addrs
+}
+
+// parseHosts parses a Redis URI listing comma-separated hosts, e.g.
+// redis+sentinel://:password@10.0.0.1:26379,10.0.0.2/mymaster/1
+// url.Parse rejects such host lists, unless every host has a port,
+// so the URI is parsed with its first host, and the hosts returned apart.
+func parseHosts(uri string) (*url.URL, []string, error) {
+	i := strings.Index(uri, "://")
+	if i < 0 {
+		u, err := url.Parse(uri)
+		return u, nil, err
+	}
+	start := i + len("://")
+
+	end := strings.IndexAny(uri[start:], "/?#")
+	if end < 0 {
+		end = len(uri)
+	} else {
+		end += start
+	}
+
+	// Hosts follow the user info, if any.
+	if at := strings.LastIndex(uri[start:end], "@"); at >= 0 {
+		start += at + 1
+	}
+
+	var hosts []string
+	if start < end {
+		hosts = strings.Split(uri[start:end], ",")
+	}
+
+	first := ""
+	if len(hosts) > 0 {
+		first = hosts[0]
+	}
+
+	u, err := url.Parse(uri[:start] + first + uri[end:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, hosts, nil
+}
+
+// DB returns the DB number selected by a Redis URI, 0 by default.
+// Sentinel URIs select it after the master name, /mymaster/1.
+func DB(uri string) (int, error) {
+	u, _, err := parseHosts(uri)
+	if err != nil {
+		return 0, err
+	}
+
+	path := strings.Trim(u.Path, "/")
+	if strings.HasSuffix(u.Scheme, "+sentinel") {
+		i := strings.Index(path, "/")
+		if i < 0 {
+			return 0, nil
+		}
+		path = path[i+1:]
+	}
+	if path == "" {
+		return 0, nil
+	}
+
+	db, err := strconv.Atoi(path)
+	if err != nil {
+		return 0, fmt.Errorf("invalid db %q", path)
+	}
+
+	return db, nil
+}
+
+// TLSConfig creates the TLS settings for rediss:// connections.
+// ca is an optional PEM bundle to verify the server,
+// cert and key an optional client certificate pair.
+// serverName overrides the name checked against the server certificate.
+// insecure skips server verification, for staging only.
+func TLSConfig(ca, cert, key, serverName string, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+	}
+
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates found in %s", ca)
+		}
+	}
+
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,35 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHosts(t *testing.T) {
+	uri := "redis+sentinel://:password@10.0.0.1:26379,10.0.0.2/mymaster/1"
+
+	u, hosts, err := parseHosts(uri)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	expected := []string{"10.0.0.1:26379", "10.0.0.2"}
+	if !reflect.DeepEqual(expected, hosts) {
+		t.Errorf("expected: %v, result: %v", expected, hosts)
+	}
+
+	if password, _ := u.User.Password(); password != "password" || u.Path != "/mymaster/1" {
+		t.Errorf("expected: password and path, result: %v", u)
+	}
+
+	if db, err := DB(uri); err != nil || db != 1 {
+		t.Errorf("expected: db 1, result: %d, %v", db, err)
+	}
+}
+
+func TestParseHostsNone(t *testing.T) {
+	_, hosts, err := parseHosts("redis+sentinel:///mymaster")
+	if err != nil || len(hosts) != 0 {
+		t.Errorf("expected: no hosts, result: %v, %v", hosts, err)
+	}
+}
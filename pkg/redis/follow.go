@@ -0,0 +1,248 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+
+	"github.com/stickermule/rump/pkg/filter"
+	"github.com/stickermule/rump/pkg/limit"
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// pingInterval is how often a keyspace subscription is checked.
+const pingInterval = 5 * time.Second
+
+// deletions are the keyspace events of keys gone from the DB.
+var deletions = map[string]bool{
+	"del":         true,
+	"expired":     true,
+	"evicted":     true,
+	"rename_from": true,
+	"move_from":   true,
+}
+
+// changes collects keyspace events, keeping the last event of each key,
+// in the order keys first changed.
+// Events are queued in memory as they come, so the subscription is never
+// blocked, e.g. during the initial scan, and dropped by the server.
+type changes struct {
+	mu     sync.Mutex
+	keys   []string
+	events map[string]string
+	err    error
+	wake   chan struct{}
+}
+
+func newChanges() *changes {
+	return &changes{
+		events: map[string]string{},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// add queues a key event.
+func (c *changes) add(key, event string) {
+	c.mu.Lock()
+	if _, ok := c.events[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.events[key] = event
+	c.mu.Unlock()
+
+	c.signal()
+}
+
+// fail stops the changes with an error, e.g. a lost subscription.
+func (c *changes) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	c.signal()
+}
+
+// signal wakes up take, without blocking.
+func (c *changes) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the keys changed since the last take, and their last event.
+func (c *changes) take() ([]string, map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys, events := c.keys, c.events
+	c.keys = nil
+	c.events = map[string]string{}
+
+	return keys, events, c.err
+}
+
+// hasEvents tells if notify-keyspace-events flags publish every key event.
+func hasEvents(flags string) bool {
+	if !strings.Contains(flags, "K") {
+		return false
+	}
+	if strings.Contains(flags, "A") {
+		return true
+	}
+
+	for _, f := range "g$lshzxe" {
+		if !strings.ContainsRune(flags, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// notify enables keyspace notifications for every key event, if permitted,
+// returning a func restoring the previous setting, to call once following
+// ends, so the source doesn't keep publishing events.
+// Managed services often deny CONFIG, notifications must then be enabled
+// in their settings, e.g. an ElastiCache parameter group.
+func notify(db radix.Client, logger *log.Logger) func() {
+	var flags []string
+	err := db.Do(radix.Cmd(&flags, "CONFIG", "GET", "notify-keyspace-events"))
+	if err == nil && len(flags) == 2 {
+		if hasEvents(flags[1]) {
+			return func() {}
+		}
+		err = db.Do(radix.Cmd(nil, "CONFIG", "SET", "notify-keyspace-events", flags[1]+"KA"))
+	}
+
+	if err != nil {
+		logger.Warn("can't enable keyspace notifications, notify-keyspace-events must include KA", log.Fields{log.Phase: "follow", log.Error: err})
+		return func() {}
+	}
+
+	return func() {
+		if err := db.Do(radix.Cmd(nil, "CONFIG", "SET", "notify-keyspace-events", flags[1])); err != nil {
+			logger.Warn("can't restore keyspace notifications", log.Fields{log.Phase: "follow", "notify-keyspace-events": flags[1], log.Error: err})
+		}
+	}
+}
+
+// subscribe subscribes to the keyspace events of a node DB,
+// queueing them until ctx is done.
+// A single Match pattern is pushed down to the subscription.
+func (r *Redis) subscribe(ctx context.Context, db radix.Client) (*changes, error) {
+	pattern := fmt.Sprintf("__keyspace@%d__:", r.DB)
+	prefix := pattern
+	if len(r.Match) == 1 {
+		pattern += r.Match[0]
+	} else {
+		pattern += "*"
+	}
+
+	c := newChanges()
+	ready := make(chan error, 1)
+
+	go func() {
+		// The subscription holds a connection of the pool until done.
+		err := db.Do(radix.WithConn("", func(conn radix.Conn) error {
+			ps := radix.PubSub(conn)
+			defer ps.Close()
+
+			msgs := make(chan radix.PubSubMessage, 100)
+			if err := ps.PSubscribe(msgs, pattern); err != nil {
+				ready <- err
+				return nil
+			}
+			ready <- nil
+
+			ticker := time.NewTicker(pingInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+					if err := ps.Ping(); err != nil {
+						return err
+					}
+				case m := <-msgs:
+					key := strings.TrimPrefix(m.Channel, prefix)
+					if filter.MatchAny(r.Match, key) {
+						c.add(key, string(m.Message))
+					}
+				}
+			}
+		}))
+		if err != nil {
+			c.fail(fmt.Errorf("redis follow: keyspace subscription lost: %v", err))
+		}
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return c, nil
+}
+
+// follow streams the keys changed on a node to the Bus until ctx is done.
+// Changed keys are read again, deleted keys are sent as Deleted Payloads.
+// Deletions can't be checked against Type, and are always sent.
+func (r *Redis) follow(ctx context.Context, db radix.Client, backoff *limit.Backoff, c *changes) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.wake:
+		}
+
+		keys, events, err := c.take()
+		if err != nil {
+			return err
+		}
+
+		for len(keys) > 0 {
+			n := r.batchSize()
+			if n > len(keys) {
+				n = len(keys)
+			}
+
+			var read []string
+			var gone []message.Payload
+			for _, key := range keys[:n] {
+				if deletions[events[key]] {
+					gone = append(gone, message.Payload{Key: key, Deleted: true})
+				} else {
+					read = append(read, key)
+				}
+			}
+			keys = keys[n:]
+
+			changed, err := r.read(ctx, db, backoff, read, true)
+			if err != nil {
+				return err
+			}
+
+			for _, p := range append(gone, changed...) {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case r.Bus <- p:
+					r.Progress.Read()
+				}
+			}
+		}
+	}
+}
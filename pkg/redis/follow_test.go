@@ -0,0 +1,48 @@
+package redis
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+func TestNotifyRestore(t *testing.T) {
+	var mu sync.Mutex
+	flags := "Ex"
+	var sets []string
+	db := radix.Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch args[1] {
+		case "GET":
+			return []string{"notify-keyspace-events", flags}
+		case "SET":
+			flags = args[3]
+			sets = append(sets, flags)
+		}
+		return "OK"
+	})
+	defer db.Close()
+
+	restore := notify(db, nil)
+	if flags != "ExKA" {
+		t.Errorf("expected: ExKA, result: %s", flags)
+	}
+
+	// The previous setting is back once following ends.
+	restore()
+	if expected := []string{"ExKA", "Ex"}; !reflect.DeepEqual(expected, sets) {
+		t.Errorf("expected: %v, result: %v", expected, sets)
+	}
+
+	// Notifications already enabled are left alone.
+	sets = nil
+	flags = "KEA"
+	notify(db, nil)()
+	if len(sets) != 0 {
+		t.Errorf("expected: no CONFIG SET, result: %v", sets)
+	}
+}
@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mediocregopher/radix/v3"
+
+	"github.com/stickermule/rump/pkg/filter"
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/progress"
+)
+
+// DefaultMirrorMax is the default cap on keys deleted by a Mirror.
+const DefaultMirrorMax = 1000
+
+// Mirror deletes the keys of a Target DB missing from the Source DB,
+// e.g. deleted since the last sync, so the Target mirrors the Source.
+// Match, Type and Keep limit the Target keys considered, same as the
+// filters of the sync, Keep being nil to consider every key.
+// DryRun only logs the keys that would be deleted.
+// Max aborts, deleting nothing, if more keys would be deleted, 0 for no cap.
+// ScanCount is the SCAN COUNT hint of the Target scan, 0 for the server default.
+// Log logs the dry run and exits, nil to discard them.
+// Progress counts keys deleted.
+type Mirror struct {
+	Source    radix.Client
+	Target    radix.Client
+	Log       *log.Logger
+	Match     []string
+	Type      string
+	Keep      func(key string) bool
+	DryRun    bool
+	Max       int
+	ScanCount int
+	Progress  *progress.Progress
+}
+
+// NewMirror creates the Mirror struct, used after a sync.
+func NewMirror(source, target radix.Client, logger *log.Logger) *Mirror {
+	return &Mirror{
+		Source: source,
+		Target: target,
+		Log:    logger,
+		Max:    DefaultMirrorMax,
+	}
+}
+
+// Run scans the Target, checks which keys still exist on the Source,
+// then deletes the others.
+// Keys are only deleted once all are known, to enforce Max.
+// To be used in an ErrGroup, after the sync writer is done.
+func (m *Mirror) Run(ctx context.Context) error {
+	var stale []string
+	var err error
+	if c, ok := m.Target.(*radix.Cluster); ok {
+		for _, node := range c.Topo().Primaries() {
+			db, err := c.Client(node.Addr)
+			if err != nil {
+				return err
+			}
+			if stale, err = m.stale(ctx, db, stale); err != nil {
+				return err
+			}
+		}
+	} else {
+		stale, err = m.stale(ctx, m.Target, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if m.Max > 0 && len(stale) > m.Max {
+		return fmt.Errorf("mirror: %d keys to delete exceed the cap of %d, nothing deleted", len(stale), m.Max)
+	}
+
+	if m.DryRun {
+		for _, key := range stale {
+			m.Log.Info("would delete", log.Fields{log.Phase: "mirror", log.Key: key})
+		}
+		m.Log.Info("dry run, nothing deleted", log.Fields{log.Phase: "mirror", "keys": len(stale)})
+		return nil
+	}
+
+	// Deleted Payloads are pipelined as DELs, like writes.
+	target := New(m.Target, nil, m.Log, false)
+	for len(stale) > 0 {
+		if err := ctx.Err(); err != nil {
+			m.Log.Debug("exit", log.Fields{log.Phase: "mirror"})
+			return err
+		}
+
+		n := target.batchSize()
+		if n > len(stale) {
+			n = len(stale)
+		}
+
+		batch := make([]message.Payload, n)
+		for i, key := range stale[:n] {
+			batch[i] = message.Payload{Key: key, Deleted: true}
+		}
+		stale = stale[n:]
+
+		if err := target.restore(batch); err != nil {
+			return err
+		}
+		for range batch {
+			m.Progress.Deleted()
+		}
+	}
+
+	return nil
+}
+
+// stale scans a Target node, appending to stale the keys
+// missing from the Source.
+func (m *Mirror) stale(ctx context.Context, db radix.Client, stale []string) ([]string, error) {
+	var match, typ string
+	if len(m.Match) == 1 {
+		match = m.Match[0]
+	}
+	if m.Type != "" && supportsScanType(db) {
+		typ = m.Type
+	}
+
+	scanner := newScanner(db, match, typ, m.ScanCount)
+	for scanner.Next() {
+		if err := ctx.Err(); err != nil {
+			m.Log.Debug("exit", log.Fields{log.Phase: "mirror"})
+			return nil, err
+		}
+
+		// Filter client-side what SCAN couldn't.
+		var keys []string
+		for _, key := range scanner.Keys() {
+			if match == "" && !filter.MatchAny(m.Match, key) {
+				continue
+			}
+			if m.Keep != nil && !m.Keep(key) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		if typ == "" && m.Type != "" {
+			target, _ := m.Target.(*radix.Cluster)
+			var err error
+			if keys, err = typed(db, target, keys, m.Type); err != nil {
+				return nil, err
+			}
+		}
+
+		found, err := exists(m.Source, keys)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			if !found[i] {
+				stale = append(stale, key)
+			}
+		}
+	}
+
+	return stale, scanner.Err()
+}
+
+// exists pipelines EXISTS for a batch of keys.
+// On a Cluster keys are checked one by one, routed to their node.
+func exists(db radix.Client, keys []string) ([]bool, error) {
+	counts := make([]int, len(keys))
+
+	if _, ok := db.(*radix.Cluster); ok {
+		for i, key := range keys {
+			if err := db.Do(radix.Cmd(&counts[i], "EXISTS", key)); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		cmds := make([]radix.CmdAction, len(keys))
+		for i, key := range keys {
+			cmds[i] = radix.Cmd(&counts[i], "EXISTS", key)
+		}
+		if err := db.Do(radix.Pipeline(cmds...)); err != nil {
+			return nil, err
+		}
+	}
+
+	found := make([]bool, len(keys))
+	for i := range counts {
+		found[i] = counts[i] > 0
+	}
+
+	return found, nil
+}
@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+func TestMirrorScanCount(t *testing.T) {
+	var scans [][]string
+	target := radix.Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "SCAN" {
+			scans = append(scans, args)
+		}
+		return []interface{}{"0", []interface{}{}}
+	})
+	defer target.Close()
+
+	m := NewMirror(nil, target, nil)
+	m.ScanCount = 500
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	expected := [][]string{{"SCAN", "0", "COUNT", "500"}}
+	if !reflect.DeepEqual(expected, scans) {
+		t.Errorf("expected: %v, result: %v", expected, scans)
+	}
+}
@@ -0,0 +1,422 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+
+	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/rdb"
+	"github.com/stickermule/rump/pkg/resp"
+)
+
+// redisError reads a Redis error reply, if that's what comes next,
+// for custom receivers to pass it on, e.g. if the key type changed after TYPE.
+func redisError(br *bufio.Reader) error {
+	if b, err := br.Peek(1); err != nil || b[0] != '-' {
+		return nil
+	}
+
+	var rerr resp2.Error
+	if err := rerr.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	return rerr
+}
+
+// entries receives XRANGE replies, keeping fields in order.
+type entries []message.Entry
+
+func (e *entries) UnmarshalRESP(br *bufio.Reader) error {
+	if err := redisError(br); err != nil {
+		return err
+	}
+
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	*e = make(entries, ah.N)
+	for i := range *e {
+		var eh resp2.ArrayHeader
+		if err := eh.UnmarshalRESP(br); err != nil {
+			return err
+		}
+		if eh.N != 2 {
+			return fmt.Errorf("invalid stream entry")
+		}
+
+		var id resp2.BulkString
+		if err := id.UnmarshalRESP(br); err != nil {
+			return err
+		}
+		(*e)[i].ID = id.S
+
+		if err := (resp2.Any{I: &(*e)[i].Fields}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// page receives SSCAN and HSCAN replies, the next cursor and elements.
+type page struct {
+	cursor string
+	items  []string
+}
+
+func (p *page) UnmarshalRESP(br *bufio.Reader) error {
+	if err := redisError(br); err != nil {
+		return err
+	}
+
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	if ah.N != 2 {
+		return fmt.Errorf("invalid scan reply")
+	}
+
+	var cursor resp2.BulkString
+	if err := cursor.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	p.cursor = cursor.S
+	p.items = nil
+
+	return (resp2.Any{I: &p.items}).UnmarshalRESP(br)
+}
+
+// keyReader reads a key by type, chunk elements at a time, so huge
+// collections never need a single huge reply.
+// Pages are read with LRANGE, SSCAN, HSCAN, ZRANGE WITHSCORES and
+// XRANGE COUNT, with SCAN guarantees for sets and hashes: a key modified
+// while being read may be read partially updated.
+// Keys of types without a native form, like modules, are DUMPed.
+type keyReader struct {
+	key    string
+	chunk  int
+	native message.Native
+	dump   string
+	value  radix.MaybeNil
+	done   bool
+
+	// Paging state and receivers.
+	offset  int
+	cursor  string
+	start   string
+	seen    map[string]bool
+	items   []string
+	page    page
+	entries entries
+}
+
+func newKeyReader(key, typ string, chunk int) *keyReader {
+	k := &keyReader{
+		key:    key,
+		chunk:  chunk,
+		native: message.Native{Type: typ},
+		cursor: "0",
+		start:  "-",
+	}
+
+	switch typ {
+	case "string":
+		k.value.Rcv = &k.native.String
+	case "list", "set", "hash", "zset", "stream":
+	default:
+		k.value.Rcv = &k.dump
+	}
+
+	return k
+}
+
+// next returns the command reading the next page into rpl.
+func (k *keyReader) next(rpl *reply) radix.CmdAction {
+	count := strconv.Itoa(k.chunk)
+	from := strconv.Itoa(k.offset)
+	to := strconv.Itoa(k.offset + k.chunk - 1)
+	k.items = nil
+
+	switch k.native.Type {
+	case "string":
+		rpl.rcv = &k.value
+		return radix.Cmd(rpl, "GET", k.key)
+	case "list":
+		rpl.rcv = &k.items
+		return radix.Cmd(rpl, "LRANGE", k.key, from, to)
+	case "set":
+		rpl.rcv = &k.page
+		return radix.Cmd(rpl, "SSCAN", k.key, k.cursor, "COUNT", count)
+	case "hash":
+		rpl.rcv = &k.page
+		return radix.Cmd(rpl, "HSCAN", k.key, k.cursor, "COUNT", count)
+	case "zset":
+		rpl.rcv = &k.items
+		return radix.Cmd(rpl, "ZRANGE", k.key, from, to, "WITHSCORES")
+	case "stream":
+		rpl.rcv = &k.entries
+		return radix.Cmd(rpl, "XRANGE", k.key, k.start, "+", "COUNT", count)
+	}
+
+	rpl.rcv = &k.value
+	return radix.Cmd(rpl, "DUMP", k.key)
+}
+
+// followed re-reads a page redirected with MOVED/ASK through the Cluster c,
+// if any, e.g. for keys migrating between nodes during a reshard,
+// returning the error of the page.
+func (k *keyReader) followed(c *radix.Cluster, rpl *reply) error {
+	if c == nil || !isRedirect(rpl.err) {
+		return rpl.err
+	}
+
+	*rpl = reply{follow: true}
+	if err := c.Do(k.next(rpl)); err != nil {
+		return err
+	}
+	return rpl.err
+}
+
+// add adds the page read by next, setting done after the last one.
+func (k *keyReader) add() error {
+	n := &k.native
+	k.done = true
+
+	switch n.Type {
+	case "list":
+		n.List = append(n.List, k.items...)
+		k.offset += k.chunk
+		k.done = len(k.items) < k.chunk
+	case "set":
+		// SSCAN may return an element more than once.
+		if k.seen == nil {
+			k.seen = map[string]bool{}
+		}
+		for _, m := range k.page.items {
+			if !k.seen[m] {
+				k.seen[m] = true
+				n.Set = append(n.Set, m)
+			}
+		}
+		k.cursor = k.page.cursor
+		k.done = k.cursor == "0"
+	case "hash":
+		if n.Hash == nil {
+			n.Hash = map[string]string{}
+		}
+		for j := 0; j+1 < len(k.page.items); j += 2 {
+			n.Hash[k.page.items[j]] = k.page.items[j+1]
+		}
+		k.cursor = k.page.cursor
+		k.done = k.cursor == "0"
+	case "zset":
+		for j := 0; j+1 < len(k.items); j += 2 {
+			score, err := strconv.ParseFloat(k.items[j+1], 64)
+			if err != nil {
+				return err
+			}
+			n.Zset = append(n.Zset, message.Member{Member: k.items[j], Score: score})
+		}
+		k.offset += k.chunk
+		k.done = len(k.items) < 2*k.chunk
+	case "stream":
+		n.Stream = append(n.Stream, k.entries...)
+		k.done = len(k.entries) < k.chunk
+		if !k.done {
+			var err error
+			if k.start, err = nextID(k.entries[len(k.entries)-1].ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// payload returns the key read, by type or as a DUMP payload.
+func (k *keyReader) payload(ttl int64) message.Payload {
+	p := message.Payload{
+		Key: k.key,
+		TTL: fmt.Sprint(ttl),
+	}
+	if k.value.Rcv == &k.dump {
+		p.Value = k.dump
+	} else {
+		p.Native = &k.native
+	}
+
+	return p
+}
+
+// nextID returns the stream ID following id,
+// as exclusive XRANGE starts need Redis 6.2.
+func nextID(id string) (string, error) {
+	i := strings.Index(id, "-")
+	if i < 0 {
+		return "", fmt.Errorf("invalid stream ID %s", id)
+	}
+
+	ms, err := strconv.ParseUint(id[:i], 10, 64)
+	if err != nil {
+		return "", err
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	if seq == math.MaxUint64 {
+		return fmt.Sprintf("%d-0", ms+1), nil
+	}
+	return fmt.Sprintf("%d-%d", ms, seq+1), nil
+}
+
+// natives pipelines TYPE, and PTTL if TTL is enabled, for a batch of keys,
+// then reads each key by its type.
+// The first page of every key is pipelined, further pages of huge
+// collections are read key by key.
+// Keys deleted or expired since the SCAN, or not of Type, are skipped.
+// Redirected keys are read through the Cluster, if any.
+func (r *Redis) natives(db radix.Client, keys []string) ([]message.Payload, error) {
+	types := make([]string, len(keys))
+	ttls := make([]int64, len(keys))
+	replies := make([]reply, 2*len(keys))
+	cmds := make([]radix.CmdAction, 0, 2*len(keys))
+
+	for i, key := range keys {
+		replies[2*i].rcv = &types[i]
+		cmds = append(cmds, radix.Cmd(&replies[2*i], "TYPE", key))
+		if r.TTL {
+			replies[2*i+1].rcv = &ttls[i]
+			cmds = append(cmds, radix.Cmd(&replies[2*i+1], "PTTL", key))
+		}
+	}
+
+	if err := db.Do(radix.Pipeline(cmds...)); err != nil {
+		return nil, err
+	}
+
+	c := r.cluster()
+	var read []int
+	var errs batchError
+	for i, key := range keys {
+		if err := redirected(c, replies[2*i].err, &types[i], "TYPE", key); err != nil {
+			errs = append(errs, keyError{key, err})
+			continue
+		}
+		if err := redirected(c, replies[2*i+1].err, &ttls[i], "PTTL", key); err != nil {
+			errs = append(errs, keyError{key, err})
+			continue
+		}
+		if types[i] == "none" || ttls[i] == -2 {
+			continue
+		}
+		if r.Type != "" && types[i] != r.Type {
+			continue
+		}
+		read = append(read, i)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	if len(read) == 0 {
+		return nil, nil
+	}
+
+	readers := make([]*keyReader, len(keys))
+	replies = make([]reply, len(keys))
+	cmds = cmds[:0]
+	for _, i := range read {
+		readers[i] = newKeyReader(keys[i], types[i], r.chunk())
+		cmds = append(cmds, readers[i].next(&replies[i]))
+	}
+
+	if err := db.Do(radix.Pipeline(cmds...)); err != nil {
+		return nil, err
+	}
+
+	payloads := make([]message.Payload, 0, len(read))
+	for _, i := range read {
+		k := readers[i]
+
+		err := k.followed(c, &replies[i])
+		if err == nil {
+			err = k.add()
+		}
+		for err == nil && !k.done {
+			var rpl reply
+			if err = db.Do(k.next(&rpl)); err != nil {
+				return nil, err
+			}
+			if err = k.followed(c, &rpl); err == nil {
+				err = k.add()
+			}
+		}
+		if err != nil {
+			errs = append(errs, keyError{k.key, err})
+			continue
+		}
+
+		// Deleted since TYPE.
+		if k.value.Nil {
+			continue
+		}
+
+		// When key has no expire PTTL returns -1.
+		// We set it to 0, default for no expiration time.
+		if ttls[i] == -1 {
+			ttls[i] = 0
+		}
+
+		payloads = append(payloads, k.payload(ttls[i]))
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	return payloads, nil
+}
+
+// decode decodes a DUMP payload, to be written with type-native commands.
+// Payloads of types without a native form, like modules, are kept as is.
+func decode(p message.Payload) (message.Payload, error) {
+	if p.Native != nil || p.Deleted {
+		return p, nil
+	}
+
+	n, err := rdb.Decode(p.Value)
+	switch {
+	case err == rdb.ErrUnsupported:
+		return p, nil
+	case err != nil:
+		return p, batchError{{p.Key, err}}
+	}
+
+	p.Native = &n
+	p.Value = ""
+	return p, nil
+}
+
+// commands returns the commands restoring a Payload,
+// RESTORE or type-native commands if it was read by type,
+// DEL if it was deleted.
+func commands(p message.Payload, chunk int) [][]string {
+	if p.Deleted {
+		return [][]string{{"DEL", p.Key}}
+	}
+
+	if p.Native != nil {
+		return resp.Commands(p.Key, *p.Native, p.TTL, chunk)
+	}
+
+	return [][]string{{"RESTORE", p.Key, p.TTL, p.Value, "REPLACE"}}
+}
@@ -0,0 +1,295 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// reply receives a pipelined command reply into rcv.
+// Redis errors are kept in err instead of aborting the pipeline,
+// which would leave the following replies unread.
+// Outside pipelines, follow returns MOVED/ASK redirections instead,
+// for a Cluster to follow them.
+type reply struct {
+	rcv    interface{}
+	err    error
+	follow bool
+}
+
+func (r *reply) UnmarshalRESP(br *bufio.Reader) error {
+	err := (resp2.Any{I: r.rcv}).UnmarshalRESP(br)
+	if e, ok := err.(resp2.Error); ok {
+		if r.follow && isRedirect(e) {
+			return e
+		}
+		r.err = e
+		return nil
+	}
+	return err
+}
+
+// keyError is a failed command on a key.
+type keyError struct {
+	key string
+	err error
+}
+
+// batchError reports every failed key of a pipelined batch.
+type batchError []keyError
+
+func (e batchError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, ke := range e {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", ke.key, ke.err))
+	}
+	return fmt.Sprintf("%d keys failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// typed pipelines TYPE for a batch of keys, keeping the ones of typ.
+// Redirected keys are retried through the Cluster c, if any.
+func typed(db radix.Client, c *radix.Cluster, keys []string, typ string) ([]string, error) {
+	types := make([]string, len(keys))
+	replies := make([]reply, len(keys))
+	cmds := make([]radix.CmdAction, len(keys))
+	for i, key := range keys {
+		replies[i].rcv = &types[i]
+		cmds[i] = radix.Cmd(&replies[i], "TYPE", key)
+	}
+
+	if err := db.Do(radix.Pipeline(cmds...)); err != nil {
+		return nil, err
+	}
+
+	var typed []string
+	var errs batchError
+	for i, key := range keys {
+		err := redirected(c, replies[i].err, &types[i], "TYPE", key)
+		switch {
+		case err != nil:
+			errs = append(errs, keyError{key, err})
+		case types[i] == typ:
+			typed = append(typed, key)
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	return typed, nil
+}
+
+// dump pipelines DUMP, and PTTL if TTL is enabled, for a batch of keys.
+// Keys deleted or expired since the SCAN are skipped.
+// Redirected keys are retried through the Cluster, if any.
+func (r *Redis) dump(db radix.Client, keys []string) ([]message.Payload, error) {
+	values := make([]string, len(keys))
+	missing := make([]radix.MaybeNil, len(keys))
+	ttls := make([]int64, len(keys))
+	replies := make([]reply, 2*len(keys))
+	cmds := make([]radix.CmdAction, 0, 2*len(keys))
+
+	for i, key := range keys {
+		missing[i].Rcv = &values[i]
+		replies[2*i].rcv = &missing[i]
+		cmds = append(cmds, radix.Cmd(&replies[2*i], "DUMP", key))
+		// noop if TTL is disabled, speeds up sync process
+		if r.TTL {
+			replies[2*i+1].rcv = &ttls[i]
+			cmds = append(cmds, radix.Cmd(&replies[2*i+1], "PTTL", key))
+		}
+	}
+
+	if err := db.Do(radix.Pipeline(cmds...)); err != nil {
+		return nil, err
+	}
+
+	c := r.cluster()
+	payloads := make([]message.Payload, 0, len(keys))
+	var errs batchError
+	for i, key := range keys {
+		if err := redirected(c, replies[2*i].err, &missing[i], "DUMP", key); err != nil {
+			errs = append(errs, keyError{key, err})
+			continue
+		}
+		if err := redirected(c, replies[2*i+1].err, &ttls[i], "PTTL", key); err != nil {
+			errs = append(errs, keyError{key, err})
+			continue
+		}
+
+		// PTTL returns -2 if the key expired in between.
+		if missing[i].Nil || ttls[i] == -2 {
+			continue
+		}
+
+		// When key has no expire PTTL returns -1.
+		// We set it to 0, default for no expiration time.
+		if ttls[i] == -1 {
+			ttls[i] = 0
+		}
+
+		payloads = append(payloads, message.Payload{
+			Key:   key,
+			Value: values[i],
+			TTL:   fmt.Sprint(ttls[i]),
+		})
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	return payloads, nil
+}
+
+// restore pipelines RESTOREs, or type-native commands, for a batch of Payloads.
+// On a Cluster the batch is split by node owning each key's slot.
+func (r *Redis) restore(batch []message.Payload) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if c, ok := r.Pool.(*radix.Cluster); ok {
+		return restoreCluster(c, batch, r.chunk())
+	}
+
+	errs, err := restoreNode(r.Pool, batch, r.chunk())
+	if err != nil {
+		return err
+	}
+
+	var failed batchError
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, keyError{batch[i].Key, err})
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+
+	return nil
+}
+
+// restoreNode pipelines the commands of each Payload on a single node,
+// returning each Payload first error, nil on success.
+func restoreNode(db radix.Client, batch []message.Payload, chunk int) ([]error, error) {
+	var replies []*reply
+	var owners []int
+	var cmds []radix.CmdAction
+	for i, p := range batch {
+		for _, cmd := range commands(p, chunk) {
+			rpl := &reply{}
+			replies = append(replies, rpl)
+			owners = append(owners, i)
+			cmds = append(cmds, radix.Cmd(rpl, cmd[0], cmd[1:]...))
+		}
+	}
+
+	if err := db.Do(radix.Pipeline(cmds...)); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(batch))
+	for j, rpl := range replies {
+		if i := owners[j]; errs[i] == nil {
+			errs[i] = rpl.err
+		}
+	}
+
+	return errs, nil
+}
+
+// restoreCluster pipelines Payloads to each node owning the keys.
+// Keys redirected with MOVED/ASK, e.g. during resharding,
+// are retried one by one through the Cluster, which follows redirections.
+func restoreCluster(c *radix.Cluster, batch []message.Payload, chunk int) error {
+	primaries := c.Topo().Primaries()
+
+	nodes := map[string][]message.Payload{}
+	for _, p := range batch {
+		addr := slotAddr(primaries, radix.ClusterSlot([]byte(p.Key)))
+		nodes[addr] = append(nodes[addr], p)
+	}
+
+	var retry []message.Payload
+	var failed batchError
+	for addr, payloads := range nodes {
+		// Unknown slot owner, let the Cluster route them.
+		if addr == "" {
+			retry = append(retry, payloads...)
+			continue
+		}
+
+		db, err := c.Client(addr)
+		if err != nil {
+			return err
+		}
+
+		errs, err := restoreNode(db, payloads, chunk)
+		if err != nil {
+			return err
+		}
+
+		for i, err := range errs {
+			switch {
+			case err == nil:
+			case isRedirect(err):
+				retry = append(retry, payloads[i])
+			default:
+				failed = append(failed, keyError{payloads[i].Key, err})
+			}
+		}
+	}
+
+	for _, p := range retry {
+		for _, cmd := range commands(p, chunk) {
+			if err := c.Do(radix.Cmd(nil, cmd[0], cmd[1:]...)); err != nil {
+				failed = append(failed, keyError{p.Key, err})
+				break
+			}
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+
+	return nil
+}
+
+// slotAddr returns the address of the primary owning a slot, if known.
+func slotAddr(primaries radix.ClusterTopo, slot uint16) string {
+	for _, node := range primaries {
+		for _, slots := range node.Slots {
+			// start is inclusive, end is exclusive
+			if slot >= slots[0] && slot < slots[1] {
+				return node.Addr
+			}
+		}
+	}
+
+	return ""
+}
+
+// redirected retries a command redirected with MOVED/ASK through the
+// Cluster c, if any, e.g. for keys migrating between nodes during a reshard.
+// Other errors are returned as is.
+func redirected(c *radix.Cluster, err error, rcv interface{}, cmd string, args ...string) error {
+	if c == nil || !isRedirect(err) {
+		return err
+	}
+	return c.Do(radix.Cmd(rcv, cmd, args...))
+}
+
+// isRedirect tells if a Cluster error is a MOVED or ASK redirection.
+func isRedirect(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "MOVED ") || strings.HasPrefix(msg, "ASK ")
+}
@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// migrating stubs a Cluster of two nodes, the first owning every slot,
+// while key migrates to the second, answering ASK for it.
+// Stubs drop the replies following an error in a pipeline,
+// so key is read last, without TTL.
+func migrating(t *testing.T, key string) (*radix.Cluster, radix.Client) {
+	const from, to = "127.0.0.1:7000", "127.0.0.1:7001"
+	values := map[string]map[string]string{
+		from: {"a": "dump-a"},
+		to:   {key: "dump-" + key},
+	}
+
+	var mu sync.Mutex
+	node := func(addr string) func(args []string) interface{} {
+		return func(args []string) interface{} {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch args[0] {
+			case "CLUSTER":
+				return []interface{}{[]interface{}{0, 16383, []interface{}{"127.0.0.1", 7000}}}
+			case "ASKING", "PING":
+				return "OK"
+			}
+
+			value, ok := values[addr][args[1]]
+			if !ok && addr == from {
+				return resp2.Error{E: fmt.Errorf("ASK %d %s", radix.ClusterSlot([]byte(args[1])), to)}
+			}
+			switch args[0] {
+			case "TYPE":
+				return "string"
+			}
+			return value
+		}
+	}
+
+	pool := func(network, addr string) (radix.Client, error) {
+		return radix.NewPool(network, addr, 1, radix.PoolConnFunc(func(network, addr string) (radix.Conn, error) {
+			return radix.Stub(network, addr, node(addr)), nil
+		}))
+	}
+
+	c, err := radix.NewCluster([]string{from}, radix.ClusterPoolFunc(pool))
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+	db, err := c.Client(from)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	return c, db
+}
+
+func TestDumpRedirect(t *testing.T) {
+	c, db := migrating(t, "k")
+	defer c.Close()
+
+	r := New(c, nil, nil, false)
+	payloads, err := r.dump(db, []string{"a", "k"})
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	if len(payloads) != 2 || payloads[0].Value != "dump-a" || payloads[1].Value != "dump-k" {
+		t.Errorf("expected: both keys, result: %v", payloads)
+	}
+}
+
+func TestNativesRedirect(t *testing.T) {
+	c, db := migrating(t, "k")
+	defer c.Close()
+
+	r := New(c, nil, nil, false)
+	payloads, err := r.natives(db, []string{"a", "k"})
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	if len(payloads) != 2 || payloads[1].Key != "k" || payloads[1].Native.String != "dump-k" {
+		t.Errorf("expected: both keys, result: %v", payloads)
+	}
+}
+
+func TestWriteOrder(t *testing.T) {
+	// Record the commands of each key, with slow RESTOREs,
+	// so deletions would overtake them on another writer.
+	var mu sync.Mutex
+	cmds := map[string][]string{}
+	pool, err := radix.NewPool("tcp", "127.0.0.1:6379", 4, radix.PoolConnFunc(func(network, addr string) (radix.Conn, error) {
+		return radix.Stub(network, addr, func(args []string) interface{} {
+			if args[0] == "RESTORE" {
+				time.Sleep(time.Millisecond)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			cmds[args[1]] = append(cmds[args[1]], args[0])
+			return "OK"
+		}), nil
+	}))
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+	defer pool.Close()
+
+	// Each key is set, then deleted, while following.
+	ch := make(message.Bus, 100)
+	go func() {
+		for i := 0; i < 100; i++ {
+			k := fmt.Sprintf("key%d", i)
+			ch <- message.Payload{Key: k, Value: "v", TTL: "0"}
+			ch <- message.Payload{Key: k, Deleted: true}
+		}
+		close(ch)
+	}()
+
+	w := New(pool, ch, nil, false)
+	w.Workers = 4
+	if err := w.Write(context.Background()); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if c := cmds[k]; len(c) != 2 || c[0] != "RESTORE" || c[1] != "DEL" {
+			t.Errorf("%s expected: [RESTORE DEL], result: %v", k, c)
+		}
+	}
+}
@@ -3,127 +3,483 @@ package redis
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/mediocregopher/radix/v3"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/stickermule/rump/pkg/checkpoint"
+	"github.com/stickermule/rump/pkg/filter"
+	"github.com/stickermule/rump/pkg/limit"
+	"github.com/stickermule/rump/pkg/log"
 	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/progress"
+	"github.com/stickermule/rump/pkg/resp"
 )
 
-// Redis holds references to a DB pool and a shared message bus.
-// Silent disables verbose mode.
+// DefaultBatchSize is the default number of RESTOREs per pipeline.
+const DefaultBatchSize = 100
+
+// DefaultBatchBytes is the default size of values per pipeline.
+const DefaultBatchBytes = 1 << 20
+
+// Redis holds references to a DB client and a shared message bus.
+// Pool is either a single node *radix.Pool or a *radix.Cluster.
+// Log logs exits and warnings, nil to discard them.
 // TTL enables TTL sync.
+// Match optionally limits reads to keys matching any glob pattern.
+// Type optionally limits reads to keys of a type.
+// Workers is the number of parallel DUMP fetchers or RESTORE writers.
+// BatchSize and BatchBytes limit the RESTOREs pipelined at once,
+// by count and by size of values.
+// Native reads keys by type, with GET, HSCAN, ..., instead of DUMP,
+// and writes DUMP payloads with type-native commands, for targets that
+// can't RESTORE them, e.g. older Redis versions.
+// Payloads read by type are always written with type-native commands.
+// Chunk is the number of collection elements per native read or write.
+// Follow keeps reading keys changed after the scan, from keyspace events
+// of DB, until the context is done.
+// Flush empties the DB before writing, with FLUSHDB ASYNC if FlushAsync.
+// Tracker, if set, checkpoints the SCAN cursor of each node once its keys
+// are written, and resumes the scan from its State.
+// MaxOps and MaxBytes limit the keys and value bytes read per second,
+// 0 for no limit.
+// Throttle backs reads off while a node exceeds its health thresholds.
+// ScanCount is the SCAN COUNT hint, 0 for the server default.
+// Progress counts keys read and written, with DBSIZE as the total on read.
 type Redis struct {
-	Pool   *radix.Pool
-	Bus    message.Bus
-	Silent bool
-	TTL    bool
+	Pool       radix.Client
+	Bus        message.Bus
+	Log        *log.Logger
+	TTL        bool
+	Match      []string
+	Type       string
+	Workers    int
+	BatchSize  int
+	BatchBytes int
+	Native     bool
+	Chunk      int
+	Follow     bool
+	DB         int
+	Flush      bool
+	FlushAsync bool
+	Tracker    *checkpoint.Tracker
+	MaxOps     int
+	MaxBytes   int
+	Throttle   limit.Thresholds
+	ScanCount  int
+	Progress   *progress.Progress
+
+	ops   *limit.Bucket
+	bytes *limit.Bucket
+}
+
+// scanBatch is a batch of keys from the scanner,
+// and the checkpoint Mark of the cursor following it.
+type scanBatch struct {
+	keys []string
+	mark *checkpoint.Mark
 }
 
 // New creates the Redis struct, used to read/write.
-func New(source *radix.Pool, bus message.Bus, silent, ttl bool) *Redis {
+func New(source radix.Client, bus message.Bus, logger *log.Logger, ttl bool) *Redis {
 	return &Redis{
-		Pool:   source,
-		Bus:    bus,
-		Silent: silent,
-		TTL:    ttl,
+		Pool: source,
+		Bus:  bus,
+		Log:  logger,
+		TTL:  ttl,
 	}
 }
 
-// maybeLog may log, depending on the Silent flag
-func (r *Redis) maybeLog(s string) {
-	if r.Silent {
-		return
+// workers returns the number of parallel fetchers/writers, at least 1.
+func (r *Redis) workers() int {
+	if r.Workers < 1 {
+		return 1
 	}
-	fmt.Print(s)
+	return r.Workers
 }
 
-// maybeTTL may sync the TTL, depending on the TTL flag
-func (r *Redis) maybeTTL(key string) (string, error) {
-	// noop if TTL is disabled, speeds up sync process
-	if !r.TTL {
-		return "0", nil
+// batchSize returns BatchSize, or its default.
+func (r *Redis) batchSize() int {
+	if r.BatchSize < 1 {
+		return DefaultBatchSize
 	}
+	return r.BatchSize
+}
 
-	var ttl string
+// batchBytes returns BatchBytes, or its default.
+func (r *Redis) batchBytes() int {
+	if r.BatchBytes < 1 {
+		return DefaultBatchBytes
+	}
+	return r.BatchBytes
+}
 
-	// Try getting key TTL.
-	err := r.Pool.Do(radix.Cmd(&ttl, "PTTL", key))
-	if err != nil {
-		return ttl, err
+// chunk returns Chunk, or its default.
+func (r *Redis) chunk() int {
+	if r.Chunk < 1 {
+		return resp.DefaultChunk
 	}
+	return r.Chunk
+}
 
-	// When key has no expire PTTL returns "-1".
-	// We set it to 0, default for no expiration time.
-	if ttl == "-1" {
-		ttl = "0"
+// cluster returns Pool if a Cluster, nil otherwise.
+func (r *Redis) cluster() *radix.Cluster {
+	c, _ := r.Pool.(*radix.Cluster)
+	return c
+}
+
+// total adds the keys of a node to the Progress total.
+// DBSIZE failures are ignored, the total is then unknown.
+func (r *Redis) total(db radix.Client) {
+	if r.Progress == nil {
+		return
 	}
 
-	return ttl, nil
+	var n int64
+	if err := db.Do(radix.Cmd(&n, "DBSIZE")); err == nil {
+		r.Progress.AddTotal(n)
+	}
 }
 
 // Read gently scans an entire Redis DB for keys, then dumps
 // the key/value pair (Payload) on the message Bus channel.
-// It leverages implicit pipelining to speedup large DB reads.
+// Each SCAN batch is dumped with a single pipeline.
+// On a Cluster every primary node is scanned, and followed, in parallel.
 // To be used in an ErrGroup.
-func (r *Redis) Read(ctx context.Context) error {
-	defer close(r.Bus)
+func (r *Redis) Read(ctx context.Context) (err error) {
+	defer r.Bus.CloseOnSuccess(&err)
 
-	scanner := radix.NewScanner(r.Pool, radix.ScanAllKeys)
+	// Limits are shared by every node.
+	r.ops = limit.NewBucket(r.MaxOps)
+	r.bytes = limit.NewBucket(r.MaxBytes)
 
-	var key string
-	var value string
-	var ttl string
+	if c, ok := r.Pool.(*radix.Cluster); ok {
+		err = r.readCluster(ctx, c)
+	} else {
+		err = r.readNode(ctx, r.Pool, "")
+	}
+
+	if err != nil && ctx.Err() != nil {
+		r.Log.Debug("exit", log.Fields{log.Phase: "read"})
+	}
+
+	return err
+}
+
+// readNode scans a single Redis node, dumping its keys on the Bus.
+// A single SCAN cursor feeds key batches to Workers parallel fetchers.
+// A single Match pattern and the Type are pushed down to SCAN when the
+// server supports it, otherwise keys are filtered client-side.
+// If Follow is enabled, keyspace events are subscribed to before the scan,
+// and keys changed since are streamed after it, until ctx is done.
+// Notifications enabled for it are then disabled again.
+// The scan resumes from the Tracker State of node, its address,
+// "" for a single node.
+// Its DBSIZE is added to the Progress total.
+func (r *Redis) readNode(ctx context.Context, db radix.Client, node string) error {
+	start := r.Tracker.State().Nodes[node]
+	if start.Done {
+		return nil
+	}
+	r.total(db)
 
-	// Scan and push to bus until no keys are left.
+	var backoff *limit.Backoff
+	if r.Throttle.Enabled() {
+		backoff = limit.NewBackoff(backoffMin, backoffMax)
+
+		mctx, stop := context.WithCancel(ctx)
+		defer stop()
+		go r.monitor(mctx, db, backoff)
+	}
+
+	var events *changes
+	if r.Follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		// Notifications are restored once the node is no longer followed.
+		restore := notify(db, r.Log)
+		defer restore()
+
+		var err error
+		if events, err = r.subscribe(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	var match, typ string
+	if len(r.Match) == 1 {
+		match = r.Match[0]
+	}
+	if r.Type != "" && supportsScanType(db) {
+		typ = r.Type
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	batches := make(chan scanBatch, r.workers())
+
+	// Scan and push to fetchers until no keys are left.
 	// If context Done, exit early.
-	for scanner.Next(&key) {
-		err := r.Pool.Do(radix.Cmd(&value, "DUMP", key))
+	g.Go(func() error {
+		defer close(batches)
+
+		scanner := newScanner(db, match, typ, r.ScanCount)
+		if start.Cursor != "" {
+			scanner.cursor = start.Cursor
+		}
+		for scanner.Next() {
+			cursor := scanner.Cursor()
+			mark := r.Tracker.Mark(node, func(s *checkpoint.State) {
+				s.Nodes[node] = checkpoint.Node{Cursor: cursor, Done: cursor == "0"}
+			})
+
+			// Filter client-side what SCAN couldn't.
+			var keys []string
+			for _, key := range scanner.Keys() {
+				if match == "" && !filter.MatchAny(r.Match, key) {
+					continue
+				}
+				keys = append(keys, key)
+			}
+			if len(keys) == 0 {
+				mark.Done()
+				continue
+			}
+
+			select {
+			case <-gctx.Done():
+				return gctx.Err()
+			case batches <- scanBatch{keys, mark}:
+			}
+		}
+
+		return scanner.Err()
+	})
+
+	for i := 0; i < r.workers(); i++ {
+		g.Go(func() error {
+			return r.fetch(gctx, db, backoff, batches, typ == "")
+		})
+	}
+
+	if err := g.Wait(); err != nil || events == nil {
+		return err
+	}
+
+	return r.follow(ctx, db, backoff, events)
+}
+
+// fetch dumps key batches as they come from the scanner,
+// pushing them on the Bus.
+// Payloads hold the batch Mark until acknowledged.
+// checkType filters keys by Type client-side.
+func (r *Redis) fetch(ctx context.Context, db radix.Client, backoff *limit.Backoff, batches <-chan scanBatch, checkType bool) error {
+	for b := range batches {
+		payloads, err := r.read(ctx, db, backoff, b.keys, checkType)
 		if err != nil {
 			return err
 		}
 
-		ttl, err = r.maybeTTL(key)
+		for _, p := range payloads {
+			p.Ack, p.Drop = b.mark.Add()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case r.Bus <- p:
+				r.Progress.Read()
+			}
+		}
+		b.mark.Done()
+	}
+
+	return nil
+}
+
+// read reads a batch of keys, by type if Native, or with DUMP,
+// within the read limits and the node Backoff.
+// checkType filters keys by Type client-side.
+func (r *Redis) read(ctx context.Context, db radix.Client, backoff *limit.Backoff, keys []string, checkType bool) ([]message.Payload, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	if err := r.wait(ctx, backoff, len(keys)); err != nil {
+		return nil, err
+	}
+
+	var payloads []message.Payload
+	var err error
+	switch {
+	// TYPE replies filter keys by Type.
+	case r.Native:
+		payloads, err = r.natives(db, keys)
+	case checkType && r.Type != "":
+		if keys, err = typed(db, r.cluster(), keys, r.Type); err == nil {
+			payloads, err = r.dump(db, keys)
+		}
+	default:
+		payloads, err = r.dump(db, keys)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Bytes read are limited on credit, delaying the next read.
+	size := 0
+	for _, p := range payloads {
+		size += p.Size()
+	}
+
+	return payloads, r.bytes.Wait(ctx, size)
+}
+
+// flush empties the DB, every primary of a Cluster.
+func (r *Redis) flush() error {
+	var args []string
+	if r.FlushAsync {
+		args = append(args, "ASYNC")
+	}
+
+	c, ok := r.Pool.(*radix.Cluster)
+	if !ok {
+		return r.Pool.Do(radix.Cmd(nil, "FLUSHDB", args...))
+	}
+
+	for _, node := range c.Topo().Primaries() {
+		db, err := c.Client(node.Addr)
 		if err != nil {
 			return err
 		}
-
-		select {
-		case <-ctx.Done():
-			fmt.Println("")
-			fmt.Println("redis read: exit")
-			return ctx.Err()
-		case r.Bus <- message.Payload{Key: key, Value: value, TTL: ttl}:
-			r.maybeLog("r")
+		if err := db.Do(radix.Cmd(nil, "FLUSHDB", args...)); err != nil {
+			return err
 		}
 	}
 
-	return scanner.Close()
+	return nil
 }
 
-// Write restores keys on the db as they come on the message bus.
+// Write restores keys on the db as they come on the message bus,
+// using Workers parallel writers.
+// Payloads of a key always go to the same writer, so its updates,
+// like a SET then a DEL while following, are applied in order.
+// Payloads are acknowledged once restored.
+// If Flush is enabled, the db is flushed before the first write.
+// On a Cluster each RESTORE is routed to the node owning the key's
+// hash slot, following MOVED/ASK redirections.
 func (r *Redis) Write(ctx context.Context) error {
+	if r.Flush {
+		if err := r.flush(); err != nil {
+			return err
+		}
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	for _, bus := range r.route(gctx, g) {
+		bus := bus
+		g.Go(func() error {
+			return r.write(gctx, bus)
+		})
+	}
+
+	err := g.Wait()
+	if err != nil && ctx.Err() != nil {
+		r.Log.Debug("exit", log.Fields{log.Phase: "write"})
+	}
+
+	return err
+}
+
+// route splits the Bus in a Bus per writer, by key hash slot.
+// A single writer reads the Bus directly.
+func (r *Redis) route(ctx context.Context, g *errgroup.Group) []message.Bus {
+	n := r.workers()
+	if n == 1 {
+		return []message.Bus{r.Bus}
+	}
+
+	buses := make([]message.Bus, n)
+	for i := range buses {
+		buses[i] = make(message.Bus, cap(r.Bus))
+	}
+
+	g.Go(func() (err error) {
+		for _, bus := range buses {
+			defer bus.CloseOnSuccess(&err)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case p, ok := <-r.Bus:
+				if !ok {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case buses[int(radix.ClusterSlot([]byte(p.Key)))%n] <- p:
+				}
+			}
+		}
+	})
+
+	return buses
+}
+
+// write is a single writer, restoring keys until its bus is closed.
+// Payloads are pipelined in batches, flushed when full or when
+// no more Payloads are waiting on the bus.
+func (r *Redis) write(ctx context.Context, bus message.Bus) error {
+	var batch []message.Payload
+	size := 0
+
 	// Loop until channel is open
-	for r.Bus != nil {
+	for bus != nil {
 		select {
 		// Exit early if context done.
 		case <-ctx.Done():
-			fmt.Println("")
-			fmt.Println("redis write: exit")
 			return ctx.Err()
 		// Get Messages from Bus
-		case p, ok := <-r.Bus:
-			// if channel closed, set to nil, break loop
+		case p, ok := <-bus:
+			// if channel closed, set to nil, flush and break loop
 			if !ok {
-				r.Bus = nil
+				bus = nil
+				break
+			}
+			if r.Native {
+				var err error
+				if p, err = decode(p); err != nil {
+					return err
+				}
+			}
+			batch = append(batch, p)
+			size += p.Size()
+			if len(bus) > 0 && len(batch) < r.batchSize() && size < r.batchBytes() {
 				continue
 			}
-			err := r.Pool.Do(radix.Cmd(nil, "RESTORE", p.Key, p.TTL, p.Value, "REPLACE"))
-			if err != nil {
-				return err
+		}
+
+		if err := r.restore(batch); err != nil {
+			if errs, ok := err.(batchError); ok {
+				r.Progress.Error(len(errs))
+			}
+			return err
+		}
+		for _, p := range batch {
+			if p.Ack != nil {
+				p.Ack()
+			}
+			if p.Deleted {
+				r.Progress.Deleted()
+			} else {
+				r.Progress.Written(p.Size())
 			}
-			r.maybeLog("w")
 		}
+		batch = batch[:0]
+		size = 0
 	}
 
 	return nil
@@ -6,9 +6,11 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/mediocregopher/radix/v3"
 
+	"github.com/stickermule/rump/pkg/checkpoint"
 	"github.com/stickermule/rump/pkg/message"
 	"github.com/stickermule/rump/pkg/redis"
 )
@@ -49,8 +51,8 @@ func TestMain(m *testing.M) {
 // Test db1 to db2 sync
 func TestReadWrite(t *testing.T) {
 	ch = make(message.Bus, 100)
-	source := redis.New(db1, ch, false, false)
-	target := redis.New(db2, ch, false, false)
+	source := redis.New(db1, ch, nil, false)
+	target := redis.New(db2, ch, nil, false)
 	ctx := context.Background()
 
 	// Read all keys from db1, push to shared message bus
@@ -80,8 +82,8 @@ func TestReadWrite(t *testing.T) {
 // Test db1 to db2 sync with TTL
 func TestReadWriteTTL(t *testing.T) {
 	ch = make(message.Bus, 100)
-	source := redis.New(db1, ch, false, true)
-	target := redis.New(db2, ch, false, true)
+	source := redis.New(db1, ch, nil, true)
+	target := redis.New(db2, ch, nil, true)
 	ctx := context.Background()
 
 	// Read all keys from db1, push to shared message bus
@@ -112,3 +114,349 @@ func TestReadWriteTTL(t *testing.T) {
 		t.Errorf("expected: %v, result: %v", expected, result)
 	}
 }
+
+// Test db1 keys filtering with MATCH and TYPE
+func TestReadMatch(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, nil, false)
+	source.Match = []string{"key1*"}
+	source.Type = "string"
+	ctx := context.Background()
+
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	// key1, key10...key19
+	result := 0
+	for range ch {
+		result++
+	}
+
+	if result != 11 {
+		t.Errorf("expected: 11 keys, result: %v", result)
+	}
+}
+
+// Test db1 read by type, for JSONL targets
+func TestReadNative(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, nil, false)
+	source.Native = true
+	ctx := context.Background()
+
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]string{}
+	for p := range ch {
+		if p.Native == nil || p.Native.Type != "string" {
+			t.Fatalf("expected: string read by type, result: %v", p)
+		}
+		result[p.Key] = p.Native.String
+	}
+
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+// Test db1 to db2 sync with parallel workers
+func TestReadWriteWorkers(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, nil, false)
+	source.Workers = 4
+	target := redis.New(db2, ch, nil, false)
+	target.Workers = 4
+	ctx := context.Background()
+
+	// Read all keys from db1, push to shared message bus
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	// Write all keys from message bus to db2
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	// Get all db2 keys
+	result := map[string]string{}
+	var v string
+	for k := range expected {
+		db2.Do(radix.Cmd(&v, "GET", k))
+		result[k] = v
+	}
+
+	// Compare db1 keys with db2 keys
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+// Test db1 to db2 sync with small pipelined batches
+func TestReadWriteBatch(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, nil, true)
+	target := redis.New(db2, ch, nil, true)
+	target.BatchSize = 3
+	target.BatchBytes = 64
+	ctx := context.Background()
+
+	// Read all keys from db1, push to shared message bus
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	// Write all keys from message bus to db2
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	// Get all db2 keys
+	result := map[string]string{}
+	var v string
+	for k := range expected {
+		db2.Do(radix.Cmd(&v, "GET", k))
+		result[k] = v
+	}
+
+	// Compare db1 keys with db2 keys
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+// Test db1 to db2 sync of collections by type, in small chunks
+func TestReadWriteNativeChunks(t *testing.T) {
+	list := []string{}
+	hash := map[string]string{}
+	for i := 1; i <= 25; i++ {
+		list = append(list, fmt.Sprintf("item%v", i))
+		hash[fmt.Sprintf("field%v", i)] = fmt.Sprintf("value%v", i)
+	}
+	db1.Do(radix.Cmd(nil, "RPUSH", append([]string{"native:list"}, list...)...))
+	db1.Do(radix.FlatCmd(nil, "HSET", "native:hash", hash))
+	defer db1.Do(radix.Cmd(nil, "DEL", "native:list", "native:hash"))
+
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, nil, false)
+	source.Match = []string{"native:*"}
+	source.Native = true
+	source.Chunk = 4
+	target := redis.New(db2, ch, nil, false)
+	target.Native = true
+	target.Chunk = 4
+	ctx := context.Background()
+
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var resultList []string
+	resultHash := map[string]string{}
+	db2.Do(radix.Cmd(&resultList, "LRANGE", "native:list", "0", "-1"))
+	db2.Do(radix.Cmd(&resultHash, "HGETALL", "native:hash"))
+
+	if !reflect.DeepEqual(list, resultList) {
+		t.Errorf("expected: %v, result: %v", list, resultList)
+	}
+	if !reflect.DeepEqual(hash, resultHash) {
+		t.Errorf("expected: %v, result: %v", hash, resultHash)
+	}
+}
+
+// Test db1 changes are followed after the initial scan
+func TestReadFollow(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, nil, false)
+	source.Match = []string{"follow:*"}
+	source.Follow = true
+	source.DB = 3
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db1.Do(radix.Cmd(nil, "SET", "follow:old", "v"))
+	defer db1.Do(radix.Cmd(nil, "DEL", "follow:old", "follow:new"))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- source.Read(ctx)
+	}()
+
+	next := func() message.Payload {
+		select {
+		case p := <-ch:
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		return message.Payload{}
+	}
+
+	if p := next(); p.Key != "follow:old" {
+		t.Errorf("expected: follow:old, result: %v", p.Key)
+	}
+
+	db1.Do(radix.Cmd(nil, "SET", "follow:new", "v"))
+	if p := next(); p.Key != "follow:new" || p.Deleted {
+		t.Errorf("expected: follow:new set, result: %v", p)
+	}
+
+	db1.Do(radix.Cmd(nil, "DEL", "follow:old"))
+	if p := next(); p.Key != "follow:old" || !p.Deleted {
+		t.Errorf("expected: follow:old deleted, result: %v", p)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error("error: ", err)
+	}
+}
+
+// Test db2 keys missing from db1 are deleted, within the filters
+func TestMirror(t *testing.T) {
+	db1.Do(radix.Cmd(nil, "SET", "mirror:kept", "v"))
+	db2.Do(radix.Cmd(nil, "SET", "mirror:kept", "v"))
+	db2.Do(radix.Cmd(nil, "SET", "mirror:stale", "v"))
+	db2.Do(radix.Cmd(nil, "SET", "other:stale", "v"))
+	defer db1.Do(radix.Cmd(nil, "DEL", "mirror:kept"))
+	defer db2.Do(radix.Cmd(nil, "DEL", "mirror:kept", "mirror:stale", "other:stale"))
+
+	mirror := redis.NewMirror(db1, db2, nil)
+	mirror.Match = []string{"mirror:*"}
+	mirror.DryRun = true
+	ctx := context.Background()
+
+	if err := mirror.Run(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var n int
+	db2.Do(radix.Cmd(&n, "EXISTS", "mirror:stale"))
+	if n != 1 {
+		t.Error("dry run should not delete keys")
+	}
+
+	mirror.DryRun = false
+	if err := mirror.Run(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]int{}
+	for _, k := range []string{"mirror:kept", "mirror:stale", "other:stale"} {
+		db2.Do(radix.Cmd(&n, "EXISTS", k))
+		result[k] = n
+	}
+
+	expected := map[string]int{"mirror:kept": 1, "mirror:stale": 0, "other:stale": 1}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+// Test db2 is flushed before writing
+func TestWriteFlush(t *testing.T) {
+	db2.Do(radix.Cmd(nil, "SET", "flush:stale", "v"))
+
+	ch = make(message.Bus, 1)
+	ch <- message.Payload{Key: "flush:new", Value: "", TTL: "0", Native: &message.Native{Type: "string", String: "v"}}
+	close(ch)
+
+	target := redis.New(db2, ch, nil, false)
+	target.Flush = true
+	target.FlushAsync = true
+	defer db2.Do(radix.Cmd(nil, "DEL", "flush:new"))
+
+	if err := target.Write(context.Background()); err != nil {
+		t.Error("error: ", err)
+	}
+
+	var stale, written int
+	db2.Do(radix.Cmd(&stale, "EXISTS", "flush:stale"))
+	db2.Do(radix.Cmd(&written, "EXISTS", "flush:new"))
+	if stale != 0 || written != 1 {
+		t.Errorf("expected: flush:new only, result: stale %v, new %v", stale, written)
+	}
+}
+
+// Test the scan is checkpointed once written, and resumed from the checkpoint
+func TestReadWriteResume(t *testing.T) {
+	ch = make(message.Bus, 100)
+	tracker := checkpoint.NewTracker(checkpoint.State{})
+	source := redis.New(db1, ch, nil, false)
+	source.Tracker = tracker
+	target := redis.New(db2, ch, nil, false)
+	ctx := context.Background()
+
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	if err := target.Write(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+
+	state := tracker.State()
+	if !state.Nodes[""].Done || state.Written != int64(len(expected)) {
+		t.Errorf("expected: scan done, %d written, result: %v", len(expected), state)
+	}
+
+	// Resuming a completed scan reads nothing.
+	ch = make(message.Bus, 100)
+	source = redis.New(db1, ch, nil, false)
+	source.Tracker = checkpoint.NewTracker(state)
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	if n := len(ch); n != 0 {
+		t.Errorf("expected: no keys, result: %d keys", n)
+	}
+}
+
+// Test reads are rate limited
+func TestReadMaxOps(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, nil, false)
+	source.MaxOps = len(expected) / 2
+	source.Throttle.Latency = time.Second
+	ctx := context.Background()
+
+	// A second worth of keys is read at once, the rest a second later.
+	start := time.Now()
+	if err := source.Read(ctx); err != nil {
+		t.Error("error: ", err)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("expected: reads over a second, result: %v", d)
+	}
+
+	if n := len(ch); n != len(expected) {
+		t.Errorf("expected: %d keys, result: %d", len(expected), n)
+	}
+}
+
+// Test reads with a SCAN COUNT hint
+func TestReadScanCount(t *testing.T) {
+	ch = make(message.Bus, 100)
+	source := redis.New(db1, ch, nil, false)
+	source.ScanCount = 1000
+
+	if err := source.Read(context.Background()); err != nil {
+		t.Error("error: ", err)
+	}
+
+	result := map[string]bool{}
+	for p := range ch {
+		result[p.Key] = true
+	}
+	for k := range expected {
+		if !result[k] {
+			t.Errorf("expected: %v, result: %v", k, result)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// scanner iterates over a Redis node keys, one SCAN batch at a time.
+// Unlike radix.Scanner it supports the TYPE option.
+type scanner struct {
+	db     radix.Client
+	args   []string
+	cursor string
+	keys   []string
+	err    error
+}
+
+// newScanner creates a scanner starting from cursor 0.
+// match and typ are optional MATCH and TYPE options,
+// count the optional COUNT hint, 0 for the server default.
+func newScanner(db radix.Client, match, typ string, count int) *scanner {
+	var args []string
+	if match != "" {
+		args = append(args, "MATCH", match)
+	}
+	if typ != "" {
+		args = append(args, "TYPE", typ)
+	}
+	if count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(count))
+	}
+
+	return &scanner{
+		db:     db,
+		args:   args,
+		cursor: "0",
+	}
+}
+
+// Next fetches the next batch of keys, returning false when the scan is
+// complete or has failed, in which case Err returns the error.
+// Batches may be empty.
+func (s *scanner) Next() bool {
+	if s.err != nil || (s.cursor == "0" && s.keys != nil) {
+		return false
+	}
+
+	var res scanResult
+	s.err = s.db.Do(radix.Cmd(&res, "SCAN", append([]string{s.cursor}, s.args...)...))
+	if s.err != nil {
+		return false
+	}
+
+	s.cursor = res.cursor
+	s.keys = res.keys
+
+	return true
+}
+
+// Keys returns the current batch of keys.
+func (s *scanner) Keys() []string {
+	return s.keys
+}
+
+// Cursor returns the cursor following the current batch,
+// "0" once the scan is complete.
+func (s *scanner) Cursor() string {
+	return s.cursor
+}
+
+// Err returns the error which stopped the scan, if any.
+func (s *scanner) Err() error {
+	return s.err
+}
+
+// scanResult is the [cursor, [keys...]] SCAN reply.
+type scanResult struct {
+	cursor string
+	keys   []string
+}
+
+func (s *scanResult) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N != 2 {
+		return errors.New("scan: not enough parts returned")
+	}
+
+	var c resp2.BulkString
+	if err := c.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	s.cursor = c.S
+	s.keys = []string{}
+
+	return (resp2.Any{I: &s.keys}).UnmarshalRESP(br)
+}
+
+// supportsScanType tells if the server understands SCAN ... TYPE,
+// available since Redis 6.0.
+func supportsScanType(db radix.Client) bool {
+	var info string
+	if err := db.Do(radix.Cmd(&info, "INFO", "server")); err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(info, "\n") {
+		if !strings.HasPrefix(line, "redis_version:") {
+			continue
+		}
+		version := strings.TrimSpace(strings.TrimPrefix(line, "redis_version:"))
+		major, err := strconv.Atoi(strings.Split(version, ".")[0])
+		return err == nil && major >= 6
+	}
+
+	return false
+}
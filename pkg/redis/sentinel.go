@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// defaultSentinelPort is used for Sentinel hosts without an explicit port.
+const defaultSentinelPort = "26379"
+
+// NewSentinel connects to a Sentinel-managed Redis from a
+// redis+sentinel:// URI, e.g.
+// redis+sentinel://:password@10.0.0.1:26379,10.0.0.2/mymaster/1
+// The current primary is resolved through Sentinel and followed on failover.
+// The password and DB apply to the data nodes, not to Sentinel itself.
+// size is the number of pooled connections per data node.
+// tlsConfig is required for rediss+sentinel://, nil otherwise,
+// and applies to both Sentinel and data nodes.
+func NewSentinel(uri string, size int, tlsConfig *tls.Config) (*radix.Sentinel, error) {
+	u, hosts, err := parseHosts(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("sentinel: no sentinel hosts in %s", uri)
+	}
+
+	addrs := withPort(hosts, defaultSentinelPort)
+
+	// Path is /mastername or /mastername/db
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	name := parts[0]
+	if name == "" {
+		return nil, fmt.Errorf("sentinel: master name is required in %s", uri)
+	}
+
+	d := Dialer{TLS: tlsConfig}
+	d.Password, _ = u.User.Password()
+	if len(parts) > 1 {
+		d.DB, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("sentinel: invalid db %q", parts[1])
+		}
+	}
+
+	// Primary and replica pools authenticate and select the DB.
+	poolFunc := func(network, addr string) (radix.Client, error) {
+		return radix.NewPool(network, addr, size, radix.PoolConnFunc(d.Dial))
+	}
+
+	// Sentinel itself is only dialed, with TLS if enabled.
+	sentinel := Dialer{TLS: tlsConfig}
+
+	return radix.NewSentinel(name, addrs,
+		radix.SentinelPoolFunc(poolFunc),
+		radix.SentinelConnFunc(sentinel.Dial),
+	)
+}
+
+// Replica returns a client to one of the Sentinel replicas,
+// keeping read load off the primary.
+// Falls back to the primary when no replica is known.
+func Replica(s *radix.Sentinel) (radix.Client, error) {
+	_, replicas := s.Addrs()
+	if len(replicas) == 0 {
+		return s, nil
+	}
+
+	return s.Client(replicas[0])
+}
@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+
+	"github.com/stickermule/rump/pkg/limit"
+	"github.com/stickermule/rump/pkg/log"
+)
+
+// throttleInterval is how often node health is sampled.
+const throttleInterval = time.Second
+
+// Reads are delayed from backoffMin up to backoffMax while throttled.
+const (
+	backoffMin = 10 * time.Millisecond
+	backoffMax = 5 * time.Second
+)
+
+// sample takes a health Sample of a node from INFO,
+// its roundtrip being the latency.
+func sample(db radix.Client) (limit.Sample, error) {
+	s := limit.Sample{At: time.Now()}
+
+	var info string
+	if err := db.Do(radix.Cmd(&info, "INFO")); err != nil {
+		return s, err
+	}
+	s.Latency = time.Since(s.At)
+
+	for _, line := range strings.Split(info, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "used_cpu_sys", "used_cpu_user":
+			cpu, _ := strconv.ParseFloat(parts[1], 64)
+			s.CPU += cpu
+		case "instantaneous_ops_per_sec":
+			s.Ops, _ = strconv.Atoi(parts[1])
+		}
+	}
+
+	return s, nil
+}
+
+// monitor samples a node until ctx is done, backing reads off while
+// it exceeds a Throttle threshold.
+// INFO failures are ignored, reads fail on their own.
+func (r *Redis) monitor(ctx context.Context, db radix.Client, b *limit.Backoff) {
+	ticker := time.NewTicker(throttleInterval)
+	defer ticker.Stop()
+
+	var prev limit.Sample
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur, err := sample(db)
+		if err != nil {
+			continue
+		}
+
+		reason := r.Throttle.Exceeded(prev, cur)
+		prev = cur
+
+		throttled := b.Delay() > 0
+		delay := b.Report(reason != "")
+		switch {
+		case delay > 0 && !throttled:
+			r.Log.Warn("source overloaded, throttling reads", log.Fields{log.Phase: "read", "reason": reason})
+		case delay == 0 && throttled:
+			r.Log.Info("source recovered, reads no longer throttled", log.Fields{log.Phase: "read"})
+		}
+	}
+}
+
+// wait waits for the Backoff of the node,
+// then for MaxOps to allow reading keys.
+func (r *Redis) wait(ctx context.Context, b *limit.Backoff, keys int) error {
+	if err := b.Wait(ctx); err != nil {
+		return err
+	}
+
+	return r.ops.Wait(ctx, keys)
+}
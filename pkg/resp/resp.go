@@ -0,0 +1,210 @@
+// Package resp allows writing to a RESP command stream file.
+//
+// Files hold RESTORE commands, or type-native commands, encoded as Redis
+// clients send them, to be piped into redis-cli --pipe or appended to an
+// append only file.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/stickermule/rump/pkg/atomicfile"
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/progress"
+	"github.com/stickermule/rump/pkg/rdb"
+)
+
+// DefaultChunk is the default number of elements per native command.
+const DefaultChunk = 1000
+
+// RESP can write, to a command stream file Path, using the message Bus.
+// Native writes type-native commands instead of RESTORE.
+// Values without a native form are still RESTOREd.
+// Log logs exits, nil to discard them.
+// Progress counts keys written.
+type RESP struct {
+	Path     string
+	Bus      message.Bus
+	Log      *log.Logger
+	TTL      bool
+	Native   bool
+	Progress *progress.Progress
+}
+
+// New creates the RESP struct, to be used for writing.
+func New(path string, bus message.Bus, logger *log.Logger, ttl bool) *RESP {
+	return &RESP{
+		Path: path,
+		Bus:  bus,
+		Log:  logger,
+		TTL:  ttl,
+	}
+}
+
+// Write writes commands restoring the Payloads from the message bus.
+// The command stream replaces Path only once complete, so an interrupted
+// Write never leaves a stream redis-cli --pipe would only partly apply.
+func (r *RESP) Write(ctx context.Context) error {
+	return atomicfile.Write(ctx, r.Path, func(w io.Writer) error {
+		return r.write(ctx, w)
+	})
+}
+
+// write writes the commands of every Payload.
+func (r *RESP) write(ctx context.Context, f io.Writer) error {
+	// Buffered write to limit system IO calls
+	w := bufio.NewWriter(f)
+
+	for r.Bus != nil {
+		select {
+		// Exit early if context done.
+		case <-ctx.Done():
+			r.Log.Debug("exit", log.Fields{log.Phase: "write"})
+			return ctx.Err()
+		// Get Messages from Bus
+		case p, ok := <-r.Bus:
+			// if channel closed, set to nil, break loop
+			if !ok {
+				r.Bus = nil
+				continue
+			}
+
+			cmds, err := r.commands(p)
+			if err != nil {
+				return err
+			}
+			for _, cmd := range cmds {
+				if err := Encode(w, cmd); err != nil {
+					return err
+				}
+			}
+			r.Progress.Written(p.Size())
+		}
+	}
+
+	return w.Flush()
+}
+
+// commands returns the commands restoring a Payload.
+// Payloads read by type are always written with native commands.
+func (r *RESP) commands(p message.Payload) ([][]string, error) {
+	ttl := "0"
+	if r.TTL {
+		ttl = p.TTL
+	}
+
+	if p.Native != nil {
+		return Commands(p.Key, *p.Native, ttl, DefaultChunk), nil
+	}
+
+	if r.Native {
+		n, err := rdb.Decode(p.Value)
+		if err == nil {
+			return Commands(p.Key, n, ttl, DefaultChunk), nil
+		}
+		if err != rdb.ErrUnsupported {
+			return nil, fmt.Errorf("resp: key %q: %v", p.Key, err)
+		}
+	}
+
+	return [][]string{{"RESTORE", p.Key, ttl, p.Value, "REPLACE"}}, nil
+}
+
+// Encode writes a command as a RESP array of bulk strings.
+func Encode(w io.Writer, cmd []string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(cmd)); err != nil {
+		return err
+	}
+
+	for _, arg := range cmd {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Commands returns the type-native commands replacing key with a value.
+// Collections are written chunk elements per command.
+// A ttl other than "0", in milliseconds, is set with PEXPIRE.
+func Commands(key string, n message.Native, ttl string, chunk int) [][]string {
+	if chunk < 1 {
+		chunk = DefaultChunk
+	}
+
+	cmds := [][]string{{"DEL", key}}
+
+	// Append args to cmd, chunk elements per command.
+	chunked := func(cmd string, size int, args []string) {
+		for i := 0; i < len(args); i += chunk * size {
+			end := i + chunk*size
+			if end > len(args) {
+				end = len(args)
+			}
+			c := append([]string{cmd, key}, args[i:end]...)
+			cmds = append(cmds, c)
+		}
+	}
+
+	switch n.Type {
+	case "string":
+		cmds = append(cmds, []string{"SET", key, n.String})
+	case "list":
+		chunked("RPUSH", 1, n.List)
+	case "set":
+		chunked("SADD", 1, n.Set)
+	case "hash":
+		var args []string
+		for _, f := range sortedFields(n.Hash) {
+			args = append(args, f, n.Hash[f])
+		}
+		chunked("HSET", 2, args)
+	case "zset":
+		var args []string
+		for _, m := range n.Zset {
+			args = append(args, Score(m.Score), m.Member)
+		}
+		chunked("ZADD", 2, args)
+	case "stream":
+		for _, e := range n.Stream {
+			cmds = append(cmds, append([]string{"XADD", key, e.ID}, e.Fields...))
+		}
+	}
+
+	if ttl != "" && ttl != "0" {
+		cmds = append(cmds, []string{"PEXPIRE", key, ttl})
+	}
+
+	return cmds
+}
+
+// Score formats a sorted set score as ZADD parses it.
+func Score(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sortedFields returns the hash fields in order, for reproducible output.
+func sortedFields(h map[string]string) []string {
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
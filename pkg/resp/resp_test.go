@@ -0,0 +1,98 @@
+package resp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// mykey is the DUMP of the integer 10 from the Redis documentation.
+const mykey = "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+
+func TestEncode(t *testing.T) {
+	var b bytes.Buffer
+	if err := Encode(&b, []string{"SET", "key", "a\r\nb"}); err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	expected := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$4\r\na\r\nb\r\n"
+	if b.String() != expected {
+		t.Errorf("expected: %q, result: %q", expected, b.String())
+	}
+}
+
+func TestCommands(t *testing.T) {
+	cases := []struct {
+		native   message.Native
+		ttl      string
+		expected [][]string
+	}{
+		{
+			message.Native{Type: "string", String: "v"},
+			"1000",
+			[][]string{{"DEL", "k"}, {"SET", "k", "v"}, {"PEXPIRE", "k", "1000"}},
+		},
+		{
+			message.Native{Type: "list", List: []string{"a", "b", "c"}},
+			"0",
+			[][]string{{"DEL", "k"}, {"RPUSH", "k", "a", "b"}, {"RPUSH", "k", "c"}},
+		},
+		{
+			message.Native{Type: "hash", Hash: map[string]string{"f2": "v2", "f1": "v1", "f3": "v3"}},
+			"0",
+			[][]string{{"DEL", "k"}, {"HSET", "k", "f1", "v1", "f2", "v2"}, {"HSET", "k", "f3", "v3"}},
+		},
+		{
+			message.Native{Type: "zset", Zset: []message.Member{{Member: "a", Score: 1.5}, {Member: "b", Score: math.Inf(1)}}},
+			"0",
+			[][]string{{"DEL", "k"}, {"ZADD", "k", "1.5", "a", "+inf", "b"}},
+		},
+		{
+			message.Native{Type: "stream", Stream: []message.Entry{{ID: "1-0", Fields: []string{"f", "v"}}}},
+			"0",
+			[][]string{{"DEL", "k"}, {"XADD", "k", "1-0", "f", "v"}},
+		},
+	}
+
+	for _, c := range cases {
+		result := Commands("k", c.native, c.ttl, 2)
+		if !reflect.DeepEqual(c.expected, result) {
+			t.Errorf("expected: %v, result: %v", c.expected, result)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	f, err := ioutil.TempFile("", "rump*.resp")
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	for native, expected := range map[bool]string{
+		false: "*5\r\n$7\r\nRESTORE\r\n$5\r\nmykey\r\n$1\r\n0\r\n$13\r\n" + mykey + "\r\n$7\r\nREPLACE\r\n",
+		true:  "*2\r\n$3\r\nDEL\r\n$5\r\nmykey\r\n*3\r\n$3\r\nSET\r\n$5\r\nmykey\r\n$2\r\n10\r\n",
+	} {
+		ch := make(message.Bus, 1)
+		ch <- message.Payload{Key: "mykey", Value: mykey, TTL: "1000"}
+		close(ch)
+
+		target := New(f.Name(), ch, nil, false)
+		target.Native = native
+		if err := target.Write(context.Background()); err != nil {
+			t.Fatal("error: ", err)
+		}
+
+		b, err := ioutil.ReadFile(f.Name())
+		if err != nil || string(b) != expected {
+			t.Errorf("native %v error: %v, expected: %q, result: %q", native, err, expected, b)
+		}
+	}
+}
@@ -2,87 +2,468 @@
 package run
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/mediocregopher/radix/v3"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/stickermule/rump/pkg/checkpoint"
 	"github.com/stickermule/rump/pkg/config"
 	"github.com/stickermule/rump/pkg/file"
+	"github.com/stickermule/rump/pkg/filter"
+	"github.com/stickermule/rump/pkg/jsonl"
+	"github.com/stickermule/rump/pkg/log"
 	"github.com/stickermule/rump/pkg/message"
+	"github.com/stickermule/rump/pkg/progress"
+	"github.com/stickermule/rump/pkg/rdb"
 	"github.com/stickermule/rump/pkg/redis"
+	"github.com/stickermule/rump/pkg/resp"
 	"github.com/stickermule/rump/pkg/signal"
+	"github.com/stickermule/rump/pkg/transform"
+	"github.com/stickermule/rump/pkg/tune"
 )
 
 // Exit helper
-func exit(e error) {
-	fmt.Println(e)
+func exit(logger *log.Logger, e error) {
+	logger.Error("sync failed", log.Fields{log.Phase: "run", log.Error: e})
 	os.Exit(1)
 }
 
+// writer wraps a target write, canceling the other goroutines once done.
+// cancel is only called on success: on errors the ErrGroup cancels them,
+// keeping the write error as its first, instead of a context.Canceled.
+func writer(cancel context.CancelFunc, write func() error) func() error {
+	return func() error {
+		if err := write(); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	}
+}
+
+// connect creates a Redis client for a Resource,
+// either a single node pool, a Cluster or a Sentinel-managed primary/replica.
+// TLS is used for rediss:// Resources.
+// size is the number of pooled connections, per node.
+func connect(res config.Resource, t config.TLS, size int) (radix.Client, error) {
+	var tlsConfig *tls.Config
+	if res.IsTLS {
+		var err error
+		tlsConfig, err = redis.TLSConfig(t.CA, t.Cert, t.Key, t.ServerName, t.Insecure)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res.IsCluster {
+		return redis.NewCluster(res.URI, size, tlsConfig)
+	}
+
+	if res.IsSentinel {
+		s, err := redis.NewSentinel(res.URI, size, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		if res.Replica {
+			return redis.Replica(s)
+		}
+		return s, nil
+	}
+
+	return redis.NewPool(res.URI, size, tlsConfig)
+}
+
+// filterStage creates a filter Stage between the in and out Buses,
+// loading allow/deny key lists.
+func filterStage(cfg config.Config, in, out message.Bus, logger *log.Logger) (*filter.Stage, error) {
+	var allow, deny map[string]bool
+	var err error
+
+	if cfg.Allow != "" {
+		allow, err = filter.ReadList(cfg.Allow)
+		if err != nil {
+			return nil, err
+		}
+		if len(allow) == 0 {
+			logger.Warn("allow list is empty, no key will be copied", log.Fields{log.Phase: "run", "allow": cfg.Allow})
+		}
+	}
+
+	if cfg.Deny != "" {
+		deny, err = filter.ReadList(cfg.Deny)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stage := filter.New(in, out, cfg.Exclude, allow, deny)
+	stage.Log = logger
+
+	return stage, nil
+}
+
+// transformStage creates a transform Stage between the in and out Buses,
+// loading the key mapping file.
+func transformStage(cfg config.Config, in, out message.Bus, logger *log.Logger) (*transform.Stage, error) {
+	var mapping map[string]string
+	var err error
+
+	if cfg.Map != "" {
+		mapping, err = transform.ReadMap(cfg.Map)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rules []transform.Rule
+	for _, r := range cfg.Rename {
+		rule, err := transform.ParseRule(r)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	stage := transform.New(in, out, mapping, cfg.StripPrefix, rules, cfg.AddPrefix)
+	stage.Log = logger
+
+	return stage, nil
+}
+
+// confirm asks a yes/no question, defaulting to no,
+// e.g. when no terminal is attached.
+func confirm(in io.Reader, prompt string) bool {
+	fmt.Print(prompt)
+
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+
+	return false
+}
+
+// mirrorStage creates a Mirror deleting target keys missing from the source,
+// considering the same keys as the sync filters.
+func mirrorStage(cfg config.Config, source, target radix.Client, logger *log.Logger, reporter *progress.Progress) (*redis.Mirror, error) {
+	stage, err := filterStage(cfg, nil, nil, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	mirror := redis.NewMirror(source, target, logger)
+	mirror.Match = cfg.Match
+	mirror.Type = cfg.Type
+	mirror.Keep = stage.Keep
+	mirror.DryRun = cfg.MirrorDryRun
+	mirror.Max = cfg.MirrorMax
+	mirror.ScanCount = cfg.ScanCount
+	mirror.Progress = reporter
+
+	return mirror, nil
+}
+
+// tracker creates the checkpoint Tracker of the sync, starting from its
+// state file if resuming, nil without State.
+func tracker(cfg config.Config, logger *log.Logger) (*checkpoint.Tracker, error) {
+	if cfg.State == "" {
+		return nil, nil
+	}
+
+	state := checkpoint.State{
+		Source: cfg.Source.Redacted(),
+		Target: cfg.Target.Redacted(),
+	}
+	if !cfg.Resume {
+		return checkpoint.NewTracker(state), nil
+	}
+
+	saved, err := checkpoint.Load(cfg.State)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing saved yet, start from scratch.
+	if saved.Source == "" {
+		return checkpoint.NewTracker(state), nil
+	}
+	if saved.Source != state.Source || saved.Target != state.Target {
+		return nil, fmt.Errorf("state %s is for a sync from %s to %s", cfg.State, saved.Source, saved.Target)
+	}
+
+	logger.Info("resuming", log.Fields{log.Phase: "run", "state": cfg.State, "written": saved.Written, "dropped": saved.Dropped})
+	return checkpoint.NewTracker(saved), nil
+}
+
 // Run orchestrate the Reader, Writer and Signal handler.
 func Run(cfg config.Config) {
+	// At least one worker, also for Configs not created by Parse.
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.Bus < 1 {
+		cfg.Bus = message.DefaultBusSize
+	}
+
+	// Readers log their source, writers their target.
+	logger := log.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	sourceLog := logger.With(log.Fields{log.Source: cfg.Source.Redacted()})
+	targetLog := logger.With(log.Fields{log.Target: cfg.Target.Redacted()})
+
+	// Ask before flushing, unless confirmed with -yes.
+	if cfg.Flush && !cfg.Yes {
+		prompt := fmt.Sprintf("flush %s before sync? [y/N] ", cfg.Target.Redacted())
+		if !confirm(os.Stdin, prompt) {
+			exit(logger, fmt.Errorf("flush not confirmed"))
+		}
+	}
+
 	// create ErrGroup to manage goroutines
 	ctx, cancel := context.WithCancel(context.Background())
 	g, gctx := errgroup.WithContext(ctx)
 
 	// Start signal handling goroutine
 	g.Go(func() error {
-		return signal.Run(gctx, cancel)
+		return signal.Run(gctx, cancel, logger)
 	})
 
+	// Report progress, unless silent, as a bar on terminals
+	// unless logging JSON.
+	var reporter *progress.Progress
+	if !cfg.Silent {
+		tty := progress.IsTTY(os.Stdout) && cfg.LogFormat != log.JSON
+		reporter = progress.New(os.Stdout, logger, tty)
+
+		g.Go(func() error {
+			return reporter.Run(gctx)
+		})
+	}
+
+	// Periodically save checkpoints, and once more on exit.
+	checkpoints, err := tracker(cfg, logger)
+	if err != nil {
+		exit(logger, err)
+	}
+	if checkpoints != nil {
+		g.Go(func() error {
+			return checkpoints.Run(gctx, cfg.State, checkpoint.DefaultInterval)
+		})
+	}
+
 	// Create shared message bus
-	ch := make(message.Bus, 100)
+	ch := make(message.Bus, cfg.Bus)
 
-	// Create and run either a Redis or File Source reader.
+	// Create and run either a Redis, RDB, JSONL or File Source reader.
+	var sourceDB radix.Client
 	if cfg.Source.IsRedis {
-		db, err := radix.NewPool("tcp", cfg.Source.URI, 1)
+		// Workers DUMP fetchers, plus the SCAN cursor,
+		// plus the keyspace subscription if following,
+		// plus the INFO monitor if throttling.
+		size := cfg.Workers + 1
+		if cfg.Follow {
+			size++
+		}
+		if cfg.Throttle.Enabled() {
+			size++
+		}
+		db, err := connect(cfg.Source, cfg.TLS, size)
 		if err != nil {
-			exit(err)
+			exit(logger, err)
 		}
+		sourceDB = db
 
-		source := redis.New(db, ch, cfg.Silent, cfg.TTL)
+		source := redis.New(db, ch, sourceLog, cfg.TTL)
+		source.Progress = reporter
+		source.Workers = cfg.Workers
+		source.Match = cfg.Match
+		source.Type = cfg.Type
+		// JSONL targets hold values by type, read them so.
+		source.Native = cfg.Mode == config.ModeNative || cfg.Target.IsJSONL
+		source.Follow = cfg.Follow
+		source.Tracker = checkpoints
+		source.MaxOps = cfg.MaxOps
+		source.MaxBytes = cfg.MaxBytes
+		source.Throttle = cfg.Throttle
+		source.ScanCount = cfg.ScanCount
+		if source.DB, err = redis.DB(cfg.Source.URI); err != nil {
+			exit(logger, err)
+		}
+
+		g.Go(func() error {
+			return source.Read(gctx)
+		})
+	} else if cfg.Source.IsRDB {
+		source := rdb.New(cfg.Source.URI, ch, sourceLog, cfg.TTL)
+		source.Progress = reporter
+		source.Match = cfg.Match
+		source.Type = cfg.Type
+		source.DB = cfg.RDBDB
+
+		g.Go(func() error {
+			return source.Read(gctx)
+		})
+	} else if cfg.Source.IsJSONL {
+		source := jsonl.New(cfg.Source.URI, ch, sourceLog, cfg.TTL)
+		source.Progress = reporter
+		source.Match = cfg.Match
+		source.Type = cfg.Type
 
 		g.Go(func() error {
 			return source.Read(gctx)
 		})
 	} else {
-		source := file.New(cfg.Source.URI, ch, cfg.Silent, cfg.TTL)
+		source := file.New(cfg.Source.URI, ch, sourceLog, cfg.TTL)
+		source.Progress = reporter
+		source.Match = cfg.Match
+		source.Type = cfg.Type
+		source.IgnoreIntegrity = cfg.IgnoreIntegrity
+		source.Tracker = checkpoints
 
 		g.Go(func() error {
 			return source.Read(gctx)
 		})
 	}
 
-	// Create and run either a Redis or File Target writer.
-	if cfg.Target.IsRedis {
-		db, err := radix.NewPool("tcp", cfg.Target.URI, 1)
+	// Optionally filter keys between the reader and the writer.
+	if len(cfg.Exclude) > 0 || cfg.Allow != "" || cfg.Deny != "" {
+		out := make(message.Bus, cfg.Bus)
+		stage, err := filterStage(cfg, ch, out, logger)
 		if err != nil {
-			exit(err)
+			exit(logger, err)
 		}
 
-		target := redis.New(db, ch, cfg.Silent, cfg.TTL)
+		g.Go(func() error {
+			return stage.Run(gctx)
+		})
+
+		ch = out
+	}
+
+	// Optionally rewrite keys before the writer.
+	if cfg.Map != "" || cfg.StripPrefix != "" || len(cfg.Rename) > 0 || cfg.AddPrefix != "" {
+		out := make(message.Bus, cfg.Bus)
+		stage, err := transformStage(cfg, ch, out, logger)
+		if err != nil {
+			exit(logger, err)
+		}
 
 		g.Go(func() error {
-			defer cancel()
-			return target.Write(gctx)
+			return stage.Run(gctx)
 		})
-	} else {
-		target := file.New(cfg.Target.URI, ch, cfg.Silent, cfg.TTL)
+
+		ch = out
+	}
+
+	// Optionally auto-tune the buffer before the writer.
+	if cfg.BusAuto {
+		out := make(message.Bus, cfg.Bus)
+		stage := tune.New(ch, out, cfg.Bus, cfg.BusMax, cfg.BusMemory)
+		stage.Log = logger
 
 		g.Go(func() error {
-			defer cancel()
-			return target.Write(gctx)
+			return stage.Run(gctx)
 		})
+
+		ch = out
+	}
+
+	// Create and run either a Redis, RESP, RDB, JSONL or File Target writer.
+	// completed is set once a Redis Target wrote everything.
+	var completed bool
+	if cfg.Target.IsRedis {
+		db, err := connect(cfg.Target, cfg.TLS, cfg.Workers)
+		if err != nil {
+			exit(logger, err)
+		}
+
+		target := redis.New(db, ch, targetLog, cfg.TTL)
+		target.Progress = reporter
+		target.Workers = cfg.Workers
+		target.BatchSize = cfg.BatchSize
+		target.BatchBytes = cfg.BatchBytes
+		target.Native = cfg.Mode == config.ModeNative
+		target.Flush = cfg.Flush
+		target.FlushAsync = cfg.FlushAsync
+
+		var mirror *redis.Mirror
+		if cfg.Mirror {
+			mirror, err = mirrorStage(cfg, sourceDB, db, targetLog, reporter)
+			if err != nil {
+				exit(logger, err)
+			}
+		}
+
+		g.Go(writer(cancel, func() error {
+			if err := target.Write(gctx); err != nil {
+				return err
+			}
+			if mirror != nil {
+				if err := mirror.Run(gctx); err != nil {
+					return err
+				}
+			}
+			completed = gctx.Err() == nil
+			return nil
+		}))
+	} else if cfg.Target.IsRESP {
+		target := resp.New(cfg.Target.URI, ch, targetLog, cfg.TTL)
+		target.Progress = reporter
+		target.Native = cfg.Mode == config.ModeNative
+
+		g.Go(writer(cancel, func() error {
+			return target.Write(gctx)
+		}))
+	} else if cfg.Target.IsRDB {
+		target := rdb.New(cfg.Target.URI, ch, targetLog, cfg.TTL)
+		target.Progress = reporter
+		target.DB = cfg.RDBDB
+
+		g.Go(writer(cancel, func() error {
+			return target.Write(gctx)
+		}))
+	} else if cfg.Target.IsJSONL {
+		target := jsonl.New(cfg.Target.URI, ch, targetLog, cfg.TTL)
+		target.Progress = reporter
+
+		g.Go(writer(cancel, func() error {
+			return target.Write(gctx)
+		}))
+	} else {
+		target := file.New(cfg.Target.URI, ch, targetLog, cfg.TTL)
+		target.Progress = reporter
+		target.Compress = cfg.Compress
+		target.Source = cfg.Source.Redacted()
+
+		g.Go(writer(cancel, func() error {
+			return target.Write(gctx)
+		}))
 	}
 
 	// Block and wait for goroutines
-	err := g.Wait()
+	err = g.Wait()
 	if err != nil && err != context.Canceled {
-		exit(err)
-	} else {
-		fmt.Println("done")
+		exit(logger, err)
+	}
+
+	// A completed sync has nothing to resume.
+	if completed && cfg.State != "" {
+		if err := os.Remove(cfg.State); err != nil && !os.IsNotExist(err) {
+			exit(logger, err)
+		}
+	}
+
+	// Final counts, unless silent.
+	done := reporter.Fields()
+	if done == nil {
+		done = log.Fields{}
 	}
+	done[log.Phase] = "run"
+	logger.Info("done", done)
 }
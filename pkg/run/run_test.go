@@ -16,6 +16,8 @@ package run_test
 import (
 	"fmt"
 	"os"
+	"os/exec"
+	"testing"
 
 	"github.com/mediocregopher/radix/v3"
 
@@ -67,9 +69,7 @@ func ExampleRun_redisToRedis() {
 
 	run.Run(cfg)
 	// Output:
-	// rw
-	// signal: exit
-	// done
+	// level=info phase=run msg=done bytes=18 deleted=0 errors=0 read=1 total=1 written=1
 }
 
 func ExampleRun_redisToRedisTTL() {
@@ -91,9 +91,7 @@ func ExampleRun_redisToRedisTTL() {
 
 	run.Run(cfg)
 	// Output:
-	// rw
-	// signal: exit
-	// done
+	// level=info phase=run msg=done bytes=18 deleted=0 errors=0 read=1 total=1 written=1
 }
 
 func ExampleRun_redisToRedisSilent() {
@@ -114,8 +112,7 @@ func ExampleRun_redisToRedisSilent() {
 
 	run.Run(cfg)
 	// Output:
-	// signal: exit
-	// done
+	// level=info phase=run msg=done
 }
 
 func ExampleRun_redisToFile() {
@@ -135,9 +132,7 @@ func ExampleRun_redisToFile() {
 
 	run.Run(cfg)
 	// Output:
-	// rw
-	// signal: exit
-	// done
+	// level=info phase=run msg=done bytes=18 deleted=0 errors=0 read=1 total=1 written=1
 }
 
 func ExampleRun_redisToFileTTL() {
@@ -158,9 +153,7 @@ func ExampleRun_redisToFileTTL() {
 
 	run.Run(cfg)
 	// Output:
-	// rw
-	// signal: exit
-	// done
+	// level=info phase=run msg=done bytes=18 deleted=0 errors=0 read=1 total=1 written=1
 }
 
 func ExampleRun_fileToRedis() {
@@ -191,12 +184,8 @@ func ExampleRun_fileToRedis() {
 	}
 	run.Run(cfg)
 	// Output:
-	// rw
-	// signal: exit
-	// done
-	// rw
-	// signal: exit
-	// done
+	// level=info phase=run msg=done bytes=18 deleted=0 errors=0 read=1 total=1 written=1
+	// level=info phase=run msg=done bytes=18 deleted=0 errors=0 read=1 total=1 written=1
 }
 
 func ExampleRun_fileToRedisTTL() {
@@ -228,10 +217,45 @@ func ExampleRun_fileToRedisTTL() {
 	}
 	run.Run(cfg)
 	// Output:
-	// rw
-	// signal: exit
-	// done
-	// rw
-	// signal: exit
-	// done
+	// level=info phase=run msg=done bytes=18 deleted=0 errors=0 read=1 total=1 written=1
+	// level=info phase=run msg=done bytes=18 deleted=0 errors=0 read=1 total=1 written=1
+}
+
+// Test a sync whose mirror exceeds its cap fails with a non-zero status,
+// run in a subprocess, since Run exits.
+func TestRunMirrorCap(t *testing.T) {
+	if os.Getenv("RUMP_MIRROR_CAP") == "1" {
+		setup()
+		db2.Do(radix.Cmd(nil, "SET", "stale1", "value"))
+		db2.Do(radix.Cmd(nil, "SET", "stale2", "value"))
+
+		run.Run(config.Config{
+			Source: config.Resource{
+				URI:     "redis://redis:6379/9",
+				IsRedis: true,
+			},
+			Target: config.Resource{
+				URI:     "redis://redis:6379/10",
+				IsRedis: true,
+			},
+			Silent:    true,
+			Mirror:    true,
+			MirrorMax: 1,
+		})
+		return
+	}
+
+	setup()
+	defer teardown()
+
+	// The cap error raced context cancellations, run it a few times.
+	for i := 0; i < 5; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=TestRunMirrorCap")
+		cmd.Env = append(os.Environ(), "RUMP_MIRROR_CAP=1")
+		err := cmd.Run()
+
+		if e, ok := err.(*exec.ExitError); !ok || e.Success() {
+			t.Fatalf("mirror cap should fail the sync, result: %v", err)
+		}
+	}
 }
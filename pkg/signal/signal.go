@@ -4,24 +4,25 @@ package signal
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+
+	"github.com/stickermule/rump/pkg/log"
 )
 
 // Run will be run in an ErrGroup supervisor.
-func Run(ctx context.Context, cancel context.CancelFunc) error {
+// The received signal, and exits, are logged to logger.
+func Run(ctx context.Context, cancel context.CancelFunc, logger *log.Logger) error {
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
 
 	select {
 	case sig := <-signalChannel:
-		fmt.Println("signal: ", sig)
+		logger.Info("signal received, exiting", log.Fields{log.Phase: "signal", "signal": sig.String()})
 		cancel()
 	case <-ctx.Done():
-		fmt.Println("")
-		fmt.Println("signal: exit")
+		logger.Debug("exit", log.Fields{log.Phase: "signal"})
 		return ctx.Err()
 	}
 
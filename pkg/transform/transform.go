@@ -0,0 +1,140 @@
+// Package transform rewrites keys on the message bus,
+// between a reader and a writer.
+package transform
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// Rule replaces regexp matches in a key.
+// Replacement can reference capture groups, e.g. $1 or ${name}.
+type Rule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseRule parses a pattern=replacement rule.
+// The pattern can't contain a literal =, use \x3d instead.
+func ParseRule(s string) (Rule, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return Rule{}, fmt.Errorf("rename: %q is not pattern=replacement", s)
+	}
+
+	pattern, err := regexp.Compile(s[:i])
+	if err != nil {
+		return Rule{}, fmt.Errorf("rename: %v", err)
+	}
+
+	return Rule{Pattern: pattern, Replacement: s[i+1:]}, nil
+}
+
+// Stage rewrites keys, between a reader and a writer.
+// In is the reader Bus, Out the writer Bus.
+// Map renames listed keys verbatim, skipping every other rewrite.
+// Otherwise StripPrefix is removed, Rules are applied in order,
+// and AddPrefix is prepended.
+// Log logs exits, nil to discard them.
+type Stage struct {
+	In          message.Bus
+	Out         message.Bus
+	Map         map[string]string
+	StripPrefix string
+	Rules       []Rule
+	AddPrefix   string
+	Log         *log.Logger
+}
+
+// New creates the transform Stage.
+func New(in, out message.Bus, mapping map[string]string, strip string, rules []Rule, add string) *Stage {
+	return &Stage{
+		In:          in,
+		Out:         out,
+		Map:         mapping,
+		StripPrefix: strip,
+		Rules:       rules,
+		AddPrefix:   add,
+	}
+}
+
+// Key returns the rewritten key.
+func (s *Stage) Key(key string) string {
+	if k, ok := s.Map[key]; ok {
+		return k
+	}
+
+	key = strings.TrimPrefix(key, s.StripPrefix)
+
+	for _, r := range s.Rules {
+		key = r.Pattern.ReplaceAllString(key, r.Replacement)
+	}
+
+	return s.AddPrefix + key
+}
+
+// Run forwards Payloads from In to Out, rewriting their keys.
+// Out is closed once In is drained.
+// To be used in an ErrGroup.
+func (s *Stage) Run(ctx context.Context) (err error) {
+	defer s.Out.CloseOnSuccess(&err)
+
+	for s.In != nil {
+		select {
+		// Exit early if context done.
+		case <-ctx.Done():
+			s.Log.Debug("exit", log.Fields{log.Phase: "transform"})
+			return ctx.Err()
+		// Get Messages from Bus
+		case p, ok := <-s.In:
+			// if channel closed, set to nil, break loop
+			if !ok {
+				s.In = nil
+				continue
+			}
+			p.Key = s.Key(p.Key)
+			select {
+			case <-ctx.Done():
+				s.Log.Debug("exit", log.Fields{log.Phase: "transform"})
+				return ctx.Err()
+			case s.Out <- p:
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadMap loads a key mapping file, one old<TAB>new pair per line.
+// Blank lines are ignored.
+func ReadMap(path string) (map[string]string, error) {
+	d, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	mapping := map[string]string{}
+
+	scanner := bufio.NewScanner(d)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		pair := strings.SplitN(line, "\t", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("map: %q is not old<TAB>new", line)
+		}
+		mapping[pair[0]] = pair[1]
+	}
+
+	return mapping, scanner.Err()
+}
@@ -0,0 +1,97 @@
+package transform
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	s := New(nil, nil, nil, "prod:", nil, "stg:")
+
+	if k := s.Key("prod:session:1"); k != "stg:session:1" {
+		t.Errorf("wrong key: %v", k)
+	}
+
+	if k := s.Key("session:1"); k != "stg:session:1" {
+		t.Errorf("wrong key: %v", k)
+	}
+}
+
+func TestKeyRules(t *testing.T) {
+	r, err := ParseRule(`^tenant(\d+):(.*)$=t$1:$2`)
+	if err != nil {
+		t.Fatal("error: ", err)
+	}
+
+	s := New(nil, nil, nil, "", []Rule{r}, "")
+
+	if k := s.Key("tenant42:cart"); k != "t42:cart" {
+		t.Errorf("wrong key: %v", k)
+	}
+
+	if k := s.Key("other"); k != "other" {
+		t.Errorf("wrong key: %v", k)
+	}
+}
+
+func TestParseRuleInvalid(t *testing.T) {
+	if _, err := ParseRule("no-separator"); err == nil {
+		t.Error("rule without separator should not work")
+	}
+
+	if _, err := ParseRule("([=x"); err == nil {
+		t.Error("invalid regexp should not work")
+	}
+}
+
+func TestKeyMap(t *testing.T) {
+	s := New(nil, nil, map[string]string{"old": "new"}, "", nil, "stg:")
+
+	if k := s.Key("old"); k != "new" {
+		t.Errorf("mapped key should skip rewrites: %v", k)
+	}
+}
+
+func TestStage(t *testing.T) {
+	in := make(message.Bus, 10)
+	out := make(message.Bus, 10)
+	s := New(in, out, nil, "", nil, "stg:")
+
+	in <- message.Payload{Key: "key1", Value: "v"}
+	close(in)
+
+	if err := s.Run(context.Background()); err != nil {
+		t.Error("error: ", err)
+	}
+
+	p := <-out
+	if p.Key != "stg:key1" || p.Value != "v" {
+		t.Errorf("wrong payload: %v", p)
+	}
+}
+
+func TestReadMap(t *testing.T) {
+	f, err := ioutil.TempFile("", "rump-map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("a\tb\n\nkey 1\tkey 2\n")
+	f.Close()
+
+	mapping, err := ReadMap(f.Name())
+	if err != nil {
+		t.Error("error: ", err)
+	}
+
+	expected := map[string]string{"a": "b", "key 1": "key 2"}
+	if !reflect.DeepEqual(expected, mapping) {
+		t.Errorf("expected: %v, result: %v", expected, mapping)
+	}
+}
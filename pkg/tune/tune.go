@@ -0,0 +1,120 @@
+// Package tune auto-tunes the buffer between a reader and a writer.
+package tune
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/stickermule/rump/pkg/log"
+	"github.com/stickermule/rump/pkg/message"
+)
+
+// interval is how often the buffer size is tuned.
+const interval = time.Second
+
+// Stage buffers Payloads between the In and Out Buses, in order.
+// Up to Size Payloads are buffered, Size doubling up to Max while the
+// buffer stays full, i.e. the writer is the bottleneck, and halving down
+// to Min while the heap exceeds Memory bytes, 0 for no memory limit.
+// Log logs exits and shrinks, nil to discard them.
+type Stage struct {
+	In     message.Bus
+	Out    message.Bus
+	Size   int
+	Min    int
+	Max    int
+	Memory uint64
+	Log    *log.Logger
+
+	// heap returns the heap size in bytes.
+	heap func() uint64
+}
+
+// New creates the tune Stage, starting with a buffer of min Payloads.
+func New(in, out message.Bus, min, max int, memory uint64) *Stage {
+	return &Stage{
+		In:     in,
+		Out:    out,
+		Size:   min,
+		Min:    min,
+		Max:    max,
+		Memory: memory,
+		heap:   heap,
+	}
+}
+
+// heap returns the bytes of allocated heap objects.
+func heap() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// Run forwards Payloads from In to Out, tuning the buffer size
+// as Payloads flow.
+// Out is closed once In is drained.
+// To be used in an ErrGroup.
+func (s *Stage) Run(ctx context.Context) (err error) {
+	defer s.Out.CloseOnSuccess(&err)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var queue []message.Payload
+	for s.In != nil || len(queue) > 0 {
+		// Only read while there's room, only write what's buffered.
+		var in, out message.Bus
+		var next message.Payload
+		if len(queue) < s.Size {
+			in = s.In
+		}
+		if len(queue) > 0 {
+			out = s.Out
+			next = queue[0]
+		}
+
+		select {
+		// Exit early if context done.
+		case <-ctx.Done():
+			s.Log.Debug("exit", log.Fields{log.Phase: "tune"})
+			return ctx.Err()
+		// Get Messages from Bus
+		case p, ok := <-in:
+			// if channel closed, set to nil, drain the buffer
+			if !ok {
+				s.In = nil
+				continue
+			}
+			queue = append(queue, p)
+		case out <- next:
+			queue[0] = message.Payload{}
+			queue = queue[1:]
+		case <-ticker.C:
+			s.tune(len(queue))
+		}
+	}
+
+	return nil
+}
+
+// tune resizes the buffer, holding buffered Payloads.
+// Memory pressure wins over a full buffer.
+func (s *Stage) tune(buffered int) {
+	switch {
+	case s.Memory > 0 && s.heap() > s.Memory:
+		if s.Size <= s.Min {
+			return
+		}
+		s.Size /= 2
+		if s.Size < s.Min {
+			s.Size = s.Min
+		}
+		s.Log.Warn("heap above memory limit, bus shrunk", log.Fields{log.Phase: "tune", "memory": s.Memory, "bus": s.Size})
+	case buffered >= s.Size && s.Size < s.Max:
+		s.Size *= 2
+		if s.Size > s.Max {
+			s.Size = s.Max
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package tune
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stickermule/rump/pkg/message"
+)
+
+func TestRun(t *testing.T) {
+	in := make(message.Bus, 10)
+	out := make(message.Bus, 10)
+
+	var expected []message.Payload
+	for i := 0; i < 10; i++ {
+		p := message.Payload{Key: fmt.Sprintf("key%d", i), Value: "v", TTL: "0"}
+		expected = append(expected, p)
+		in <- p
+	}
+	close(in)
+
+	s := New(in, out, 2, 8, 0)
+	go s.Run(context.Background())
+
+	var result []message.Payload
+	for p := range out {
+		result = append(result, p)
+	}
+
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected: %v, result: %v", expected, result)
+	}
+}
+
+func TestTune(t *testing.T) {
+	var used uint64
+	s := New(nil, nil, 100, 400, 1000)
+	s.heap = func() uint64 { return used }
+
+	cases := []struct {
+		buffered int
+		heap     uint64
+		size     int
+	}{
+		// Not full, unchanged.
+		{50, 0, 100},
+		// Full, grown up to Max.
+		{100, 0, 200},
+		{200, 0, 400},
+		{400, 0, 400},
+		// Memory pressure, shrunk down to Min, even if full.
+		{400, 2000, 200},
+		{200, 2000, 100},
+		{100, 2000, 100},
+	}
+
+	for _, c := range cases {
+		used = c.heap
+		s.tune(c.buffered)
+		if s.Size != c.size {
+			t.Errorf("buffered %d, heap %d: expected: %d, result: %d", c.buffered, c.heap, c.size, s.Size)
+		}
+	}
+}